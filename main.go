@@ -6,6 +6,7 @@ import (
 	"./utils/config"
 	"flag"
 	"fmt"
+	"os"
 )
 
 var buildNumber string
@@ -39,6 +40,11 @@ func main() {
 		}
 	}
 
+	if err := c.ApplyGeoIPConf(flag.CommandLine); err != nil {
+		print("Invalid MaxMind config provided: " + err.Error())
+		os.Exit(1)
+	}
+
 	s := server.NewServerConfig(c)
 	s.Start()
 }
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AccessListRecord is the content of the access list file as well as the
+// request and response body of the admin endpoint.
+type AccessListRecord struct {
+	Deny  []string `json:"deny"`
+	Allow []string `json:"allow"`
+}
+
+// AccessList denies or allows clients by their ip. Allowed clients bypass
+// all quotas, denied clients are rejected. Allow entries take precedence.
+type AccessList struct {
+	file  string
+	mutex sync.RWMutex
+	deny  []*net.IPNet
+	allow []*net.IPNet
+}
+
+// NewAccessList loads the given file, if any, and reloads it whenever it
+// changes. The file is created by the first change made through the admin
+// endpoint if it does not exist.
+func NewAccessList(file string, silent bool) (*AccessList, error) {
+	al := &AccessList{file: file}
+	if file == "" {
+		return al, nil
+	}
+	if err := al.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := watchFile(file, al.Load, silent); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// Load replaces both lists with the content of the file.
+func (al *AccessList) Load() error {
+	content, err := ioutil.ReadFile(al.file)
+	if err != nil {
+		return err
+	}
+	record := &AccessListRecord{}
+	if err := json.Unmarshal(content, record); err != nil {
+		return fmt.Errorf("invalid access list %s: %v", al.file, err)
+	}
+	return al.Set(record)
+}
+
+// Set replaces both lists.
+func (al *AccessList) Set(record *AccessListRecord) error {
+	deny, allow, err := parseRecord(record)
+	if err != nil {
+		return err
+	}
+
+	al.mutex.Lock()
+	al.deny, al.allow = deny, allow
+	al.mutex.Unlock()
+	return nil
+}
+
+// Record returns both lists in CIDR notation.
+func (al *AccessList) Record() *AccessListRecord {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+	return &AccessListRecord{Deny: formatNetworks(al.deny), Allow: formatNetworks(al.allow)}
+}
+
+// Update adds or removes the given entries and writes the result to the
+// file if there is one. The lists are only changed if the file has been
+// written, concurrent updates are applied one after another.
+func (al *AccessList) Update(change *AccessListRecord, remove bool) error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	record := &AccessListRecord{Deny: formatNetworks(al.deny), Allow: formatNetworks(al.allow)}
+	if remove {
+		record.Deny = without(record.Deny, change.Deny)
+		record.Allow = without(record.Allow, change.Allow)
+	} else {
+		record.Deny = append(record.Deny, change.Deny...)
+		record.Allow = append(record.Allow, change.Allow...)
+	}
+	deny, allow, err := parseRecord(record)
+	if err != nil {
+		return err
+	}
+
+	if al.file != "" {
+		content, err := json.MarshalIndent(&AccessListRecord{Deny: formatNetworks(deny), Allow: formatNetworks(allow)}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(al.file, content, 0644); err != nil {
+			return err
+		}
+	}
+	al.deny, al.allow = deny, allow
+	return nil
+}
+
+// Allowed reports whether the ip is part of the allowlist.
+func (al *AccessList) Allowed(ip net.IP) bool {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+	return containsIP(al.allow, ip)
+}
+
+// Denied reports whether the ip is part of the denylist and not part of
+// the allowlist.
+func (al *AccessList) Denied(ip net.IP) bool {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+	return containsIP(al.deny, ip) && !containsIP(al.allow, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRecord parses both lists of the record.
+func parseRecord(record *AccessListRecord) (deny []*net.IPNet, allow []*net.IPNet, err error) {
+	if deny, err = parseNetworks(record.Deny); err != nil {
+		return nil, nil, err
+	}
+	if allow, err = parseNetworks(record.Allow); err != nil {
+		return nil, nil, err
+	}
+	return deny, allow, nil
+}
+
+// parseNetworks parses a list of networks in CIDR notation. Single ips
+// are accepted as well.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range list {
+		n, err := parseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func parseNetwork(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip or network: %s", entry)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip or network: %s", entry)
+	}
+	return n, nil
+}
+
+func formatNetworks(networks []*net.IPNet) []string {
+	list := []string{}
+	for _, n := range networks {
+		list = append(list, n.String())
+	}
+	return list
+}
+
+// without returns all entries of list which are not part of remove.
+func without(list []string, remove []string) []string {
+	var result []string
+	for _, entry := range list {
+		keep := true
+		for _, r := range remove {
+			if n, err := parseNetwork(r); err == nil && n.String() == entry {
+				keep = false
+			}
+		}
+		if keep {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// accessListMiddleware rejects denied clients before any quota is charged.
+func (s *Server) accessListMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AccessList.Denied(net.ParseIP(s.clientHost(r))) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": strings.ToLower(http.StatusText(http.StatusForbidden))})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AccessListAdmin returns the access list on GET, adds the entries of the
+// request body on POST and removes them on DELETE.
+func (s *Server) AccessListAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		change := &AccessListRecord{}
+		if err := json.NewDecoder(r.Body).Decode(change); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if err := s.AccessList.Update(change, r.Method == http.MethodDelete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(s.AccessList.Record())
+}
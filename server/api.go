@@ -2,10 +2,12 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"../utils/updater"
 	"github.com/go-web/httpmux"
 	"github.com/mileusna/useragent"
 	"github.com/pariz/gountries"
@@ -15,49 +17,95 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
-	"strconv"
+	"net/url"
 	"strings"
 )
 
-// openDB opens and returns the IP database file or URL.
+// openDB opens the databases according to their mode. A database which
+// fails to open does not keep the others from being opened, the first
+// error is returned.
 func (s *Server) openDB() error {
-	if _, err := s.Api.i2lDB.Start(); err != nil {return err}
-	if _, err := s.Api.torDB.Start(); err != nil {return err}
-	if _, err := s.Api.db.Start(); err != nil {return err}
-	if _, err := s.Api.asnDB.Start(); err != nil {return err}
+	starts := []func() (*updater.Config, error){
+		s.Api.i2lDB.Start,
+		s.Api.torDB.Start,
+		s.Api.db.Start,
+		s.Api.asnDB.Start,
+	}
+	for _, edition := range s.Api.editions {
+		starts = append(starts, edition.Start)
+	}
+	if s.Api.overrideDB != nil {
+		starts = append(starts, s.Api.overrideDB.Start)
+	}
 
-	return nil
+	var failed error
+	for _, start := range starts {
+		if u, err := start(); err != nil && failed == nil {
+			failed = fmt.Errorf("%s: %v", u.File, err)
+		}
+	}
+	return failed
 }
 
 func (s *Server) IpLookUp(writer writerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		host := getRequestParam(r, "host")
-		ips, err := net.LookupIP(host)
-		if err != nil || len(ips) == 0 {
+		fields, err := getRequestFields(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := s.lookupHost(host, fields, r)
+		if err == ErrHostNotFound {
 			http.NotFound(w, r)
 			return
+		} else if err != nil {
+			http.Error(w, "Try again later.", http.StatusServiceUnavailable)
+			return
 		}
 
-		ip, q := ips[rand.Intn(len(ips))], &GeoIpQuery{}
+		w.Header().Set("X-Database-Date", s.Api.db.Updater.Date().Format(http.TimeFormat))
+		writer(w, r, resp)
+	}
+}
+
+// lookupHost resolves the given ip or hostname and builds the response
+// record for it. Databases which are not required by the selected fields
+// are skipped.
+func (s *Server) lookupHost(host string, fields Fields, r *http.Request) (*ResponseRecord, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, ErrHostNotFound
+	}
+
+	ip, q := ips[rand.Intn(len(ips))], &GeoIpQuery{fields: fields}
+	if fields.Needs("location", "network.isp", "network.domain", "network.tld") && s.Api.db.Updater.Enabled() {
 		if err := s.Api.db.Lookup(ip, &q.DefaultQuery); err != nil {
 			fmt.Println(err)
-			http.Error(w, "Try again later.", http.StatusServiceUnavailable)
-			return
+			return nil, ErrUnavailable
 		}
+	}
+	if fields.Needs("network.as") && s.Api.asnDB.Updater.Enabled() {
 		if err := s.Api.asnDB.Lookup(ip, &q.ASNDefaultQuery); err != nil {
-			http.Error(w, "Try again later.", http.StatusServiceUnavailable)
-			return
+			return nil, ErrUnavailable
 		}
+	}
 
+	if fields.Needs("network.proxy", "network.proxy_type", "network.usage_type", "network.last_seen") && s.Api.i2lDB.Updater.Enabled() {
 		q.ProxyDefaultQuery = s.Api.i2lDB.Lookup(ip)
-
-		w.Header().Set("X-Database-Date", s.Api.db.Updater.Date().Format(http.TimeFormat))
-		lang := getRequestParam(r, "lang")
-
+	}
+	if fields.Needs("network.tor") && s.Api.torDB.Updater.Enabled() {
 		q.IsTorUser = s.Api.torDB.Lookup(ip)
-		resp := q.Record(ip, lang, r)
-		writer(w, r, resp)
 	}
+
+	o := s.lookupOverride(ip, q)
+	lang := getRequestParam(r, "lang")
+	rr := q.Record(ip, lang, r)
+	if o != nil {
+		applyOverride(rr, o)
+	}
+	return rr, nil
 }
 
 func (q *GeoIpQuery) Translate(names map[string]string, lang string) string {
@@ -93,9 +141,13 @@ func (q *GeoIpQuery) Record(ip net.IP, lang string, request *http.Request) *Resp
 	//CountryCode: 		q.Country.ISOCode,
 	//ContinentCode: 		q.Translate(q.Continent.Names, lang),
 	//CountryName: 		q.Translate(q.Country.Names, lang),
-	query := gountries.New()
-	country, err := query.FindCountryByAlpha(q.Country.ISOCode)
-	if err != nil {
+	var country gountries.Country
+	var err error
+	enrich := q.fields.Needs(countryDetailFields()...)
+	if enrich {
+		country, err = gountries.New().FindCountryByAlpha(q.Country.ISOCode)
+	}
+	if err != nil || !enrich {
 		country = gountries.Country{
 			Name: struct {
 				gountries.BaseLang `yaml:",inline"`
@@ -128,9 +180,10 @@ func (q *GeoIpQuery) Record(ip net.IP, lang string, request *http.Request) *Resp
 			City:           q.Translate(q.City.Names, lang),
 			ZipCode:        q.Postal.Code,
 			TimeZone:       q.Location.TimeZone,
-			Latitude:       roundFloat(q.Location.Latitude, .5, 4),
-			Longitude:      roundFloat(q.Location.Longitude, .5, 4),
+			Latitude:       roundFloat(floatValue(q.Location.Latitude), .5, 4),
+			Longitude:      roundFloat(floatValue(q.Location.Longitude), .5, 4),
 			AccuracyRadius: q.Location.AccuracyRadius,
+			located:        q.Location.Latitude != nil && q.Location.Longitude != nil,
 			Country:        &CountryRecord{
 				Code: q.Country.ISOCode,
 				Name: country.Name.Common,
@@ -171,6 +224,8 @@ func (q *GeoIpQuery) Record(ip net.IP, lang string, request *http.Request) *Resp
 			LastSeen:	uint(q.LastSeen),
 		},
 		User: &UserRecord{},
+
+		fields: q.fields,
 	}
 
 	if len(q.Region) > 0 {
@@ -178,7 +233,7 @@ func (q *GeoIpQuery) Record(ip net.IP, lang string, request *http.Request) *Resp
 		r.Location.RegionName = q.Region[0].Names[lang]
 	}
 
-	if len(request.URL.Query()["user"]) > 0 {
+	if len(request.URL.Query()["user"]) > 0 && q.fields.Needs("system", "user", "network.bot") {
 		t, qq, _ := language.ParseAcceptLanguage(request.Header.Get("Accept-Language"))
 
 		plang := getMostPreferredLanguage(t, qq)
@@ -212,128 +267,8 @@ func (rr *ResponseRecord) String() string {
 	b := &bytes.Buffer{}
 	w := csv.NewWriter(b)
 	w.UseCRLF = true
-	var err error
 
-	currency := make([]string, len(rr.Location.Country.Currency))
-	for i := range rr.Location.Country.Currency {
-		currency[i] = rr.Location.Country.Currency[i].Code
-	}
-	var SystemBot int;if rr.Network.Bot {SystemBot = 1}
-	var SystemTor int;if rr.Network.Tor {SystemTor = 1}
-	var SystemProxy int;if rr.Network.Proxy {SystemProxy = 1}
-
-	if rr.User != nil && rr.System != nil {
-
-		var SystemMobile int;if rr.System.Mobile {SystemMobile = 1}
-		var SystemTablet int;if rr.System.Tablet {SystemTablet = 1}
-		var SystemDesktop int;if rr.System.Desktop {SystemDesktop = 1}
-
-		err = w.Write([]string{
-			rr.Network.IP,
-			strconv.Itoa(int(rr.Network.AS.Number)),
-			rr.Network.AS.Name,
-			rr.Network.Isp,
-			rr.Network.Domain,
-			strings.Join(rr.Network.Tld, "/"),
-			strconv.Itoa(SystemBot),
-			strconv.Itoa(SystemTor),
-			strconv.Itoa(SystemProxy),
-			rr.Network.ProxyType,
-			strconv.Itoa(int(rr.Network.LastSeen)),
-			rr.Network.UsageType,
-
-			rr.Location.RegionCode,
-			rr.Location.RegionName,
-			rr.Location.City,
-			strconv.Itoa(int(rr.Location.MetroCode)),
-			rr.Location.ZipCode,
-			rr.Location.TimeZone,
-			strconv.FormatFloat(rr.Location.Longitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Latitude, 'f', 4, 64),
-			strconv.Itoa(int(rr.Location.AccuracyRadius)),
-
-			rr.Location.Country.Code,
-			rr.Location.Country.CIOC,
-			rr.Location.Country.CCN3,
-			strings.Join(rr.Location.Country.CallCode, "/"),
-			rr.Location.Country.InternationalPrefix,
-			rr.Location.Country.Capital,
-			rr.Location.Country.Name,
-			rr.Location.Country.FullName,
-			strconv.FormatFloat(rr.Location.Country.Area, 'f', 4, 64),
-			strings.Join(rr.Location.Country.Borders, "/"),
-			strconv.FormatFloat(rr.Location.Country.Latitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.Longitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MaxLatitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MaxLongitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MinLatitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MinLongitude, 'f', 4, 64),
-			strings.Join(currency, "/"),
-			rr.Location.Country.Continent.Code,
-			rr.Location.Country.Continent.Name,
-			rr.Location.Country.Continent.SubRegion,
-
-			rr.System.OS,
-			rr.System.Browser,
-			rr.System.Version,
-			rr.System.OSVersion,
-			rr.System.Device,
-			strconv.Itoa(SystemMobile),
-			strconv.Itoa(SystemTablet),
-			strconv.Itoa(SystemDesktop),
-
-			rr.User.Language.Language,
-			rr.User.Language.Region,
-			rr.User.Language.Tag,
-		})
-	}else{
-		err = w.Write([]string{
-			rr.Network.IP,
-			strconv.Itoa(int(rr.Network.AS.Number)),
-			rr.Network.AS.Name,
-			rr.Network.Isp,
-			rr.Network.Domain,
-			strings.Join(rr.Network.Tld, "/"),
-			strconv.Itoa(SystemBot),
-			strconv.Itoa(SystemTor),
-			strconv.Itoa(SystemProxy),
-			rr.Network.ProxyType,
-			strconv.Itoa(int(rr.Network.LastSeen)),
-			rr.Network.UsageType,
-
-			rr.Location.RegionCode,
-			rr.Location.RegionName,
-			rr.Location.City,
-			strconv.Itoa(int(rr.Location.MetroCode)),
-			rr.Location.ZipCode,
-			rr.Location.TimeZone,
-			strconv.FormatFloat(rr.Location.Longitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Latitude, 'f', 4, 64),
-			strconv.Itoa(int(rr.Location.AccuracyRadius)),
-
-			rr.Location.Country.Code,
-			rr.Location.Country.CIOC,
-			rr.Location.Country.CCN3,
-			strings.Join(rr.Location.Country.CallCode, "/"),
-			rr.Location.Country.InternationalPrefix,
-			rr.Location.Country.Capital,
-			rr.Location.Country.Name,
-			rr.Location.Country.FullName,
-			strconv.FormatFloat(rr.Location.Country.Area, 'f', 4, 64),
-			strings.Join(rr.Location.Country.Borders, "/"),
-			strconv.FormatFloat(rr.Location.Country.Latitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.Longitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MaxLatitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MaxLongitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MinLatitude, 'f', 4, 64),
-			strconv.FormatFloat(rr.Location.Country.MinLongitude, 'f', 4, 64),
-			strings.Join(currency, "/"),
-			rr.Location.Country.Continent.Code,
-			rr.Location.Country.Continent.Name,
-			rr.Location.Country.Continent.SubRegion,
-		})
-	}
-	if err != nil {
+	if err := w.Write(rr.csvColumns()); err != nil {
 		return ""
 	}
 	w.Flush()
@@ -351,10 +286,7 @@ func getRequestParam(r *http.Request, param string) string {
 			host = strings.Split(host, "?")[0]
 		}
 		if host == "" {
-			host, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if host == "" {
-				host = r.RemoteAddr
-			}
+			host = remoteHost(r)
 		}
 		return host
 	case "lang":
@@ -372,6 +304,33 @@ func getRequestParam(r *http.Request, param string) string {
 	return ""
 }
 
+// remoteHost returns the ip address of the client.
+func remoteHost(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if host == "" {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// lookupRequest creates a request carrying the lookup parameters expected
+// by GeoIpQuery.Record for api endpoints without regular query parameters.
+func lookupRequest(ctx context.Context, header http.Header, lang string, user bool) *http.Request {
+	query := url.Values{}
+	if lang != "" {
+		query.Set("lang", lang)
+	}
+	if user {
+		query.Set("user", "")
+	}
+
+	r := &http.Request{
+		URL:    &url.URL{RawQuery: query.Encode()},
+		Header: header,
+	}
+	return r.WithContext(ctx)
+}
+
 func parseAcceptLanguage(header string, dbLangs map[string]string) string {
 	// supported languages -- i.e. languages available in the DB
 	matchLangs := []language.Tag{
@@ -395,6 +354,14 @@ func parseAcceptLanguage(header string, dbLangs map[string]string) string {
 	return base.String()
 }
 
+// floatValue returns the value of an optional database field or 0.
+func floatValue(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
 func roundFloat(val float64, roundOn float64, places int) (newVal float64) {
 	var round float64
 	pow := math.Pow(10, float64(places))
@@ -410,6 +377,15 @@ func roundFloat(val float64, roundOn float64, places int) (newVal float64) {
 
 func csvResponse(w http.ResponseWriter, r *http.Request, d *ResponseRecord) {
 	w.Header().Set("Content-Type", "text/csv")
+	if csvHeaderRequested(r) {
+		c := csv.NewWriter(w)
+		c.UseCRLF = true
+		if err := c.Write(csvColumnNames(csvHeader(d.fields, d.User != nil && d.System != nil))); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		c.Flush()
+	}
 	if n, err := io.WriteString(w, d.String()); err != nil || n <= 0 {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a single entry of the api keys file.
+type APIKey struct {
+	Key       string   `json:"key"`
+	Name      string   `json:"name"`
+	Limit     int      `json:"limit"`     // Requests per second, 0 uses QUOTA_MAX
+	Burst     int      `json:"burst"`     // Max requests at once, 0 uses QUOTA_BURST
+	Origins   []string `json:"origins"`   // Allowed Origin headers, all if empty
+	Endpoints []string `json:"endpoints"` // Allowed path patterns, all if empty
+}
+
+// APIKeyUsage counts the requests of an api key since the server started.
+type APIKeyUsage struct {
+	Name     string    `json:"name"`
+	Limit    int       `json:"limit"`
+	Burst    int       `json:"burst"`
+	Requests uint64    `json:"requests"`
+	Rejected uint64    `json:"rejected"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// APIKeyStore holds all api keys of the keys file together with their
+// usage counters. Counters survive reloads of the file.
+type APIKeyStore struct {
+	file  string
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+	usage map[string]*APIKeyUsage
+}
+
+// NewAPIKeyStore loads the given keys file and reloads it whenever it
+// changes.
+func NewAPIKeyStore(file string, silent bool) (*APIKeyStore, error) {
+	st := &APIKeyStore{
+		file:  file,
+		keys:  make(map[string]*APIKey),
+		usage: make(map[string]*APIKeyUsage),
+	}
+	if err := st.Load(); err != nil {
+		return nil, err
+	}
+	if err := watchFile(file, st.Load, silent); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Load replaces all keys with the content of the keys file. The file is a
+// json array of APIKey objects.
+func (st *APIKeyStore) Load() error {
+	content, err := ioutil.ReadFile(st.file)
+	if err != nil {
+		return err
+	}
+	var list []*APIKey
+	if err := json.Unmarshal(content, &list); err != nil {
+		return fmt.Errorf("invalid api keys file %s: %v", st.file, err)
+	}
+
+	keys := make(map[string]*APIKey, len(list))
+	for _, k := range list {
+		if k.Key == "" {
+			return fmt.Errorf("invalid api keys file %s: empty key for %q", st.file, k.Name)
+		}
+		keys[k.Key] = k
+	}
+
+	st.mutex.Lock()
+	st.keys = keys
+	st.mutex.Unlock()
+	return nil
+}
+
+// Get returns the api key or nil if it does not exist.
+func (st *APIKeyStore) Get(key string) *APIKey {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+	return st.keys[key]
+}
+
+// Usage returns a copy of the usage counters of the api key.
+func (st *APIKeyStore) Usage(k *APIKey) APIKeyUsage {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+	usage := APIKeyUsage{}
+	if u, ok := st.usage[k.Key]; ok {
+		usage = *u
+	}
+	usage.Name = k.Name
+	return usage
+}
+
+// count records a request of the api key.
+func (st *APIKeyStore) count(k *APIKey, rejected bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	u, ok := st.usage[k.Key]
+	if !ok {
+		u = &APIKeyUsage{}
+		st.usage[k.Key] = u
+	}
+	u.Requests++
+	if rejected {
+		u.Rejected++
+	}
+	u.LastUsed = time.Now()
+}
+
+// bucket returns the rate limit key of the api key. The key itself is
+// hashed so that it is not stored in any quota backend.
+func (k *APIKey) bucket() string {
+	sum := sha256.Sum256([]byte(k.Key))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+// policy returns the limiter settings of the api key.
+func (k *APIKey) policy(fallback Policy) Policy {
+	p := fallback
+	if k.Limit > 0 {
+		p.Limit = k.Limit
+	}
+	if k.Burst > 0 {
+		p.Burst = k.Burst
+	}
+	return p
+}
+
+// allowsOrigin reports whether the api key may be used by the origin.
+// Requests without Origin header are always allowed.
+func (k *APIKey) allowsOrigin(origin string) bool {
+	if origin == "" || len(k.Origins) == 0 {
+		return true
+	}
+	for _, o := range k.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsEndpoint reports whether the api key may request the path, which
+// is relative to the api prefix. Endpoints are matched as path patterns,
+// e.g. /json/* or /graphql.
+func (k *APIKey) allowsEndpoint(p string) bool {
+	if len(k.Endpoints) == 0 {
+		return true
+	}
+	for _, pattern := range k.Endpoints {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKey returns the api key of the request from the X-API-Key header or
+// the key query parameter.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+// Usage writes the usage counters of the api key used for the request.
+func (s *Server) Usage(w http.ResponseWriter, r *http.Request) {
+	rq := requestQuotaFrom(r)
+	if rq == nil || rq.apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	usage := s.APIKeys.Usage(rq.apiKey)
+	usage.Limit, usage.Burst = rq.policy.Limit, rq.policy.Burst
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(usage)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-web/httpmux"
 	"github.com/rs/cors"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 
@@ -21,21 +22,45 @@ type writerFunc func(w http.ResponseWriter, r *http.Request, d *ResponseRecord)
 // can be embedded in other servers.
 func (s *Server) NewHandler() (http.Handler, error) {
 	s.initCors()
+	if err := s.initGraphQL(); err != nil {
+		return nil, err
+	}
 	mc := httpmux.DefaultConfig
 	if err := s.initMiddlewares(&mc); err != nil {
 		return nil, err
 	}
 	mux := httpmux.NewHandler(&mc)
-	mux.GET("/csv/*host",  s.registerHandler(csvResponse))
-	mux.GET("/xml/*host",  s.registerHandler(xmlResponse))
-	mux.GET("/json/*host", s.registerHandler(jsonResponse))
+	preflight := map[string]bool{}
+	routes := s.routes()
+	if err := s.validateCosts(routes); err != nil {
+		return nil, err
+	}
+	for _, rt := range routes {
+		if rt.handler == nil {
+			continue
+		}
+		if rt.admin {
+			rt.handler = s.adminHandler(rt.handler)
+		}
+		mux.HandleFunc(rt.method, rt.path, s.quotaHandler(rt))
+		if rt.method != http.MethodGet && !preflight[rt.path] {
+			// Answer CORS preflight requests
+			mux.HandleFunc(http.MethodOptions, rt.path, s.quotaHandler(rt))
+			preflight[rt.path] = true
+		}
+	}
+	if err := s.initOpenAPI(routes); err != nil {
+		return nil, err
+	}
 	return mux, nil
 }
 
 func (s *Server) initCors() {
 	s.Api.cors = cors.New(cors.Options{
 		AllowedOrigins:   strings.Split(s.Config.CORSOrigin, ","),
-		AllowedMethods:   []string{"GET"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "X-API-Key"},
+		ExposedHeaders:   []string{"X-Database-Date", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 	})
 }
@@ -62,6 +87,14 @@ func (s *Server) registerHandler(writer writerFunc) http.HandlerFunc {
 	return s.Api.cors.Handler(s.IpLookUp(writer)).ServeHTTP
 }
 
+func (s *Server) registerBatchHandler(writer batchWriterFunc) http.HandlerFunc {
+	return s.Api.cors.Handler(s.BatchLookUp(writer)).ServeHTTP
+}
+
+func (s *Server) registerBulkHandler(format *bulkFormat) http.HandlerFunc {
+	return s.Api.cors.Handler(s.BulkLookUp(format)).ServeHTTP
+}
+
 func (s *Server) listenerOpts() []listener.Option {
 	var opts []listener.Option
 	if s.Config.FastOpen {
@@ -87,7 +120,7 @@ func (s *Server) runServer(f http.Handler) {
 		WriteTimeout: s.Config.WriteTimeout,
 		ErrorLog:     s.Config.ErrorLogger(),
 	}
-	log.Fatal(srv.Serve(ln))
+	s.serveHTTP(srv, ln)
 }
 
 func (s *Server) runTLSServer(f http.Handler) {
@@ -120,7 +153,7 @@ func (s *Server) runTLSServer(f http.Handler) {
 		ErrorLog:     s.Config.ErrorLogger(),
 		TLSConfig:    ln.TLSConfig(),
 	}
-	log.Fatal(srv.Serve(ln))
+	s.serveHTTP(srv, ln)
 }
 
 func (s *Server) initMiddlewares(mc *httpmux.Config) error {
@@ -135,22 +168,83 @@ func (s *Server) initMiddlewares(mc *httpmux.Config) error {
 	if s.Config.HSTS != "" {
 		mc.UseFunc(hstsMiddleware(s.Config.HSTS))
 	}
-	if s.Config.RateLimitLimit > 0 {
+	if s.Config.AccessListFile != "" || s.Config.AdminToken != "" {
+		al, err := NewAccessList(s.Config.AccessListFile, s.Config.Silent)
+		if err != nil {
+			return err
+		}
+		s.AccessList = al
+		mc.Use(s.accessListMiddleware)
+	}
+	if s.Config.APIKeysFile != "" {
+		keys, err := NewAPIKeyStore(s.Config.APIKeysFile, s.Config.Silent)
+		if err != nil {
+			return err
+		}
+		s.APIKeys = keys
+	}
+	policies, err := newQuotaPolicies(s.Config.RateLimitPolicies)
+	if err != nil {
+		return err
+	}
+	s.policies, s.policyFields = policies, policyFields(policies)
+	if len(s.policies) > 0 {
+		s.policyCache = newPolicyCache(s.Config.RateLimitInterval)
+	}
+	if s.Config.RateLimitLimit > 0 || s.APIKeys != nil || s.Config.APIKeyRequired || len(s.policies) > 0 {
+		limiter, err := NewLimiter(s.Config)
+		if err != nil {
+			return err
+		}
+		s.RateLimit = limiter
 		mc.Use(s.rateLimitMiddleware)
 	}
 	return nil
 }
 
-func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		limiter := s.RateLimit.GetLimiter(r.RemoteAddr)
-		if !limiter.Allow() {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-			return
+// clientHost returns the address of the client. If UseXForwardedFor is
+// set, it is the entry of the X-Forwarded-For header added by the furthest
+// trusted proxy. Entries left of it are controlled by the client and never
+// used.
+func (s *Server) clientHost(r *http.Request) string {
+	host := remoteHost(r)
+	if s.Config.UseXForwardedFor {
+		var hops []string
+		for _, xff := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(xff, ",")...)
+		}
+		if len(hops) > 0 {
+			n := s.Config.XForwardedForHops
+			if n < 1 {
+				n = 1
+			} else if n > len(hops) {
+				n = len(hops)
+			}
+			host = strings.TrimSpace(hops[len(hops)-n])
 		}
+	}
+	return strings.Trim(host, "[]")
+}
 
-		next.ServeHTTP(w, r)
-	})
+// rateLimitKey returns the key of the quota the request counts against.
+// All clients within the same subnet share one quota.
+func (s *Server) rateLimitKey(r *http.Request) string {
+	host := s.clientHost(r)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(clampPrefix(s.Config.RateLimitIPv4Prefix, 32), 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(clampPrefix(s.Config.RateLimitIPv6Prefix, 128), 128)).String()
+}
+
+func clampPrefix(prefix int, bits int) int {
+	if prefix <= 0 || prefix > bits {
+		return bits
+	}
+	return prefix
 }
 
 func hstsMiddleware(policy string) httpmux.MiddlewareFunc {
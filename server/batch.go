@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// maxBatchEntrySize is the number of body bytes allowed per batch entry,
+// enough for a quoted hostname of the maximum length and its separator.
+const maxBatchEntrySize = 256
+
+type batchWriterFunc func(w http.ResponseWriter, r *http.Request, d []*BatchRecord)
+
+// BatchRecord holds the result of a single batch entry. Either Error or
+// Record is set.
+type BatchRecord struct {
+	Query  string          `json:"query" xml:"query,attr"`
+	Error  string          `json:"error,omitempty" xml:"error,omitempty"`
+	Record *ResponseRecord `json:"record,omitempty" xml:"Response,omitempty"`
+}
+
+type BatchResponse struct {
+	XMLName xml.Name       `xml:"Batch"`
+	Records []*BatchRecord `xml:"Record"`
+}
+
+// BatchLookUp resolves a list of ips or hostnames provided within the
+// request body and returns one record per entry in the same order.
+func (s *Server) BatchLookUp(writer batchWriterFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := getRequestFields(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.Config.BatchLimit > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(s.Config.BatchLimit)*maxBatchEntrySize)
+		}
+		hosts, err := parseBatchBody(r.Body)
+		if _, ok := err.(*http.MaxBytesError); ok {
+			http.Error(w, fmt.Sprintf("Batch size exceeds the limit of %d entries.", s.Config.BatchLimit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil || len(hosts) == 0 {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if s.Config.BatchLimit > 0 && len(hosts) > s.Config.BatchLimit {
+			http.Error(w, fmt.Sprintf("Batch size exceeds the limit of %d entries.", s.Config.BatchLimit), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// The request itself already accounted for the first entry
+		if err := s.takeQuota(w, r, s.batchCost(r, hosts)); err != nil {
+			return
+		}
+
+		w.Header().Set("X-Database-Date", s.Api.db.Updater.Date().Format(http.TimeFormat))
+		writer(w, r, s.lookupHosts(hosts, fields, r))
+	}
+}
+
+// lookupHosts performs a concurrent lookup of all given hosts while
+// preserving their order.
+func (s *Server) lookupHosts(hosts []string, fields Fields, r *http.Request) []*BatchRecord {
+	records := make([]*BatchRecord, len(hosts))
+	sem := make(chan struct{}, runtime.NumCPU())
+	wg := sync.WaitGroup{}
+
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			records[i] = &BatchRecord{Query: host}
+			resp, err := s.lookupHost(host, fields, r)
+			if err != nil {
+				records[i].Error = err.Error()
+				return
+			}
+			records[i].Record = resp
+		}(i, host)
+	}
+	wg.Wait()
+
+	return records
+}
+
+// parseBatchBody reads either a json array of strings or a newline,
+// comma or whitespace separated list of ips and hostnames.
+func parseBatchBody(body io.Reader) ([]string, error) {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	content = bytes.TrimSpace(content)
+
+	var hosts []string
+	if len(content) > 0 && content[0] == '[' {
+		if err := json.Unmarshal(content, &hosts); err != nil {
+			return nil, err
+		}
+	} else {
+		hosts = strings.FieldsFunc(string(content), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+		})
+	}
+
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host != "" {
+			result = append(result, host)
+		}
+	}
+	return result, nil
+}
+
+func csvBatchResponse(w http.ResponseWriter, r *http.Request, d []*BatchRecord) {
+	w.Header().Set("Content-Type", "text/csv")
+	c := csv.NewWriter(w)
+	c.UseCRLF = true
+	if csvHeaderRequested(r) {
+		fields, _ := getRequestFields(r)
+		header := append([]string{"query", "error"}, csvColumnNames(csvHeader(fields, len(r.URL.Query()["user"]) > 0))...)
+		if err := c.Write(header); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, record := range d {
+		row := []string{record.Query, record.Error}
+		if record.Record != nil {
+			row = append(row, record.Record.csvColumns()...)
+		}
+		if err := c.Write(row); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	c.Flush()
+}
+
+func xmlBatchResponse(w http.ResponseWriter, r *http.Request, d []*BatchRecord) {
+	w.Header().Set("Content-Type", "application/xml")
+	x := xml.NewEncoder(w)
+	x.Indent("", "\t")
+	if err := x.Encode(&BatchResponse{Records: d}); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if n, err := w.Write([]byte{'\n'}); err != nil || n <= 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+func jsonBatchResponse(w http.ResponseWriter, r *http.Request, d []*BatchRecord) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The binary formats use the json field names and therefore carry exactly
+// the same fields as the json output.
+
+func msgpackResponse(w http.ResponseWriter, r *http.Request, d *ResponseRecord) {
+	w.Header().Set("Content-Type", "application/msgpack")
+	b := &bytes.Buffer{}
+	enc := msgpack.NewEncoder(b)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(d); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if n, err := w.Write(b.Bytes()); err != nil || n <= 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+func cborResponse(w http.ResponseWriter, r *http.Request, d *ResponseRecord) {
+	w.Header().Set("Content-Type", "application/cbor")
+	b, err := cbor.Marshal(d)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if n, err := w.Write(b); err != nil || n <= 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+// protoResponse writes the record as ResponseRecord message as described
+// by the schema served under /geoip.proto.
+func protoResponse(w http.ResponseWriter, r *http.Request, d *ResponseRecord) {
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	b, err := marshalProto(d)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	// An empty message is a valid response
+	if _, err := w.Write(b); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+func (rr *ResponseRecord) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if len(rr.fields) == 0 {
+		return enc.Encode((*responseRecord)(rr))
+	}
+	return enc.Encode(selectFields(reflect.ValueOf(rr), rr.fields.tree()))
+}
+
+func (rr *ResponseRecord) MarshalCBOR() ([]byte, error) {
+	if len(rr.fields) == 0 {
+		return cbor.Marshal((*responseRecord)(rr))
+	}
+	return cbor.Marshal(selectFields(reflect.ValueOf(rr), rr.fields.tree()))
+}
+
+func (s selection) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeMapLen(len(s)); err != nil {
+		return err
+	}
+	for _, f := range s {
+		if err := enc.EncodeString(f.json); err != nil {
+			return err
+		}
+		if err := enc.Encode(f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalCBOR encodes the selection as map while preserving the field
+// order.
+func (s selection) MarshalCBOR() ([]byte, error) {
+	b := cborHead(5, uint64(len(s)))
+	for _, f := range s {
+		key, err := cbor.Marshal(f.json)
+		if err != nil {
+			return nil, err
+		}
+		value, err := cbor.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(b, key...), value...)
+	}
+	return b, nil
+}
+
+// cborHead returns the initial bytes of a cbor data item of the given
+// major type and length.
+func cborHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{major | 25, byte(n >> 8), byte(n)}
+	case n <= 0xffffffff:
+		return []byte{major | 26, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return []byte{major | 27, byte(n >> 56), byte(n >> 48), byte(n >> 40), byte(n >> 32),
+		byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
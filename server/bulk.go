@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBulkLineSize is the longest line accepted by the bulk endpoints.
+const maxBulkLineSize = 4096
+
+type bulkWriterFunc func(w http.ResponseWriter, r *http.Request, d *BatchRecord) error
+
+// bulkFormat describes a streamed response. The prefix and suffix enclose
+// all records, which are separated by the separator.
+type bulkFormat struct {
+	contentType string
+	prefix      string
+	separator   string
+	suffix      string
+	writer      bulkWriterFunc
+}
+
+var ndjsonBulkFormat = &bulkFormat{
+	contentType: "application/x-ndjson",
+	writer:      ndjsonResponse,
+}
+
+var geoJSONBulkFormat = &bulkFormat{
+	contentType: geoJSONContentType,
+	prefix:      `{"type":"FeatureCollection","features":[` + "\n",
+	separator:   ",\n",
+	suffix:      "\n]}\n",
+	writer:      geoJSONBulkResponse,
+}
+
+// BulkLookUp reads newline delimited ips or hostnames from the request
+// body and streams one record per line back as soon as it is available.
+// Only a single line is held in memory at any time.
+func (s *Server) BulkLookUp(format *bulkFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := getRequestFields(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// Allow reading the request body while already writing the response
+		_ = rc.EnableFullDuplex()
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("X-Database-Date", s.Api.db.Updater.Date().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.WriteString(w, format.prefix); err != nil {
+			return
+		}
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 1024), maxBulkLineSize)
+		first := true
+		for scanner.Scan() {
+			host := strings.TrimSpace(scanner.Text())
+			if host == "" {
+				continue
+			}
+
+			// The request itself already accounted for the first entry
+			cost := s.hostCost(host)
+			if !first {
+				cost = s.entryCost(r, host)
+			}
+			if err := s.waitQuota(r, cost); err != nil {
+				return
+			}
+			if !first {
+				if _, err := io.WriteString(w, format.separator); err != nil {
+					return
+				}
+			}
+			first = false
+
+			record := &BatchRecord{Query: host}
+			if resp, err := s.lookupHost(host, fields, r); err != nil {
+				record.Error = err.Error()
+			} else {
+				record.Record = resp
+			}
+			if err := format.writer(w, r, record); err != nil {
+				return
+			}
+			_ = rc.Flush()
+
+			// Treat the configured timeouts as idle timeouts for the stream
+			if s.Config.ReadTimeout > 0 {
+				_ = rc.SetReadDeadline(time.Now().Add(s.Config.ReadTimeout))
+			}
+			if s.Config.WriteTimeout > 0 {
+				_ = rc.SetWriteDeadline(time.Now().Add(s.Config.WriteTimeout))
+			}
+		}
+		// Leave the stream unterminated, so a truncated body is noticeable
+		if err := scanner.Err(); err != nil {
+			log.Println("bulk lookup:", err)
+			return
+		}
+		_, _ = io.WriteString(w, format.suffix)
+	}
+}
+
+// ndjsonResponse writes a single record in the same shape as the json
+// endpoint. Failed lookups are written as {"query": ..., "error": ...}.
+func ndjsonResponse(w http.ResponseWriter, r *http.Request, d *BatchRecord) error {
+	if d.Record != nil {
+		return json.NewEncoder(w).Encode(d.Record)
+	}
+	return json.NewEncoder(w).Encode(d)
+}
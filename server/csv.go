@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// csvColumnPaths defines the columns of the default csv output as dotted
+// json paths. Values of nested lists are joined by "/". The system and
+// user columns are only present if the user parameter is set.
+var csvColumnPaths = []string{
+	"network.ip",
+	"network.as.number",
+	"network.as.name",
+	"network.isp",
+	"network.domain",
+	"network.tld",
+	"network.bot",
+	"network.tor",
+	"network.proxy",
+	"network.proxy_type",
+	"network.last_seen",
+	"network.usage_type",
+
+	"location.region_code",
+	"location.region_name",
+	"location.city",
+	"location.metro_code",
+	"location.zip_code",
+	"location.time_zone",
+	"location.longitude",
+	"location.latitude",
+	"location.accuracy_radius",
+
+	"location.country.code",
+	"location.country.cioc",
+	"location.country.ccn3",
+	"location.country.call_code",
+	"location.country.international_prefix",
+	"location.country.capital",
+	"location.country.name",
+	"location.country.full_name",
+	"location.country.area",
+	"location.country.borders",
+	"location.country.latitude",
+	"location.country.longitude",
+	"location.country.max_latitude",
+	"location.country.max_longitude",
+	"location.country.min_latitude",
+	"location.country.min_longitude",
+	"location.country.currency.code",
+	"location.country.continent.code",
+	"location.country.continent.name",
+	"location.country.continent.sub_region",
+
+	"system.os",
+	"system.browser",
+	"system.version",
+	"system.os_version",
+	"system.device",
+	"system.mobile",
+	"system.tablet",
+	"system.desktop",
+
+	"user.language.language",
+	"user.language.region",
+	"user.language.tag",
+}
+
+// csvColumn describes a single column of the csv output.
+type csvColumn struct {
+	name string
+	typ  string
+}
+
+// csvPaths returns the column paths of the default csv output.
+func csvPaths(user bool) []string {
+	var paths []string
+	for _, path := range csvColumnPaths {
+		if !user && (strings.HasPrefix(path, "system.") || strings.HasPrefix(path, "user.")) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// csvRow returns the record as a list of csv columns.
+func (rr *ResponseRecord) csvRow() []string {
+	var columns []string
+	for _, path := range csvPaths(rr.User != nil && rr.System != nil) {
+		columns = append(columns, csvFields(reflect.ValueOf(rr), reflect.TypeOf(rr), strings.Split(path, "."))...)
+	}
+	return columns
+}
+
+// csvHeader returns the columns produced by the given field selection. If
+// no fields are selected, the default columns are returned.
+func csvHeader(fields Fields, user bool) []csvColumn {
+	paths := []string(fields)
+	if len(paths) == 0 {
+		paths = csvPaths(user)
+	}
+
+	var columns []csvColumn
+	for _, path := range paths {
+		t, list := reflect.TypeOf(ResponseRecord{}), false
+		for _, part := range strings.Split(path, ".") {
+			t, list = csvElem(t, list)
+			for i := 0; i < t.NumField(); i++ {
+				if name, ok := jsonFieldName(t.Field(i)); ok && name == part {
+					t = t.Field(i).Type
+					break
+				}
+			}
+		}
+		columns = append(columns, csvLeaves(t, path, list)...)
+	}
+	return columns
+}
+
+// csvLeaves returns one column for every leaf of the given type in the
+// same order as csvFields.
+func csvLeaves(t reflect.Type, path string, list bool) []csvColumn {
+	t, list = csvElem(t, list)
+	if t.Kind() != reflect.Struct {
+		return []csvColumn{{name: path, typ: csvType(t, list)}}
+	}
+
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonFieldName(t.Field(i)); ok {
+			columns = append(columns, csvLeaves(t.Field(i).Type, path+"."+name, list)...)
+		}
+	}
+	return columns
+}
+
+// csvElem dereferences pointers and slices. Slices mark the value as list.
+func csvElem(t reflect.Type, list bool) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		if t.Kind() == reflect.Slice {
+			list = true
+		}
+		t = t.Elem()
+	}
+	return t, list
+}
+
+func csvType(t reflect.Type, list bool) string {
+	typ := "string"
+	switch t.Kind() {
+	case reflect.Bool:
+		typ = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		typ = "integer"
+	case reflect.Float32, reflect.Float64:
+		typ = "number"
+	}
+	if list {
+		return "list of " + typ
+	}
+	return typ
+}
+
+func csvColumnNames(columns []csvColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// csvHeaderRequested reports whether the header parameter is set.
+func csvHeaderRequested(r *http.Request) bool {
+	header, _ := strconv.ParseBool(r.URL.Query().Get("header"))
+	return header
+}
+
+// CsvLookUp serves the csv lookup as well as the column schema. The schema
+// is part of this handler as /csv/schema would conflict with the catch-all
+// route.
+func (s *Server) CsvLookUp() http.HandlerFunc {
+	lookup := s.IpLookUp(csvResponse)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getRequestParam(r, "host") == "schema" {
+			s.CsvSchema(w, r)
+			return
+		}
+		lookup(w, r)
+	}
+}
+
+// CsvSchema lists the name and type of every column returned by the csv
+// endpoints for the given fields and user parameters.
+func (s *Server) CsvSchema(w http.ResponseWriter, r *http.Request) {
+	fields, err := getRequestFields(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	c := csv.NewWriter(w)
+	c.UseCRLF = true
+	if err := c.Write([]string{"name", "type"}); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	for _, column := range csvHeader(fields, len(r.URL.Query()["user"]) > 0) {
+		if err := c.Write([]string{column.name, column.typ}); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	c.Flush()
+}
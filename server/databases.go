@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"../utils/updater"
+)
+
+// DatabaseRecord describes the state of a database.
+type DatabaseRecord struct {
+	Name       string    `json:"name"`
+	Mode       string    `json:"mode"`
+	File       string    `json:"file"`
+	Updated    time.Time `json:"updated"`    // Build or release date of the database
+	Downloaded time.Time `json:"downloaded"` // Time of the last download, zero if never downloaded
+	Previous   bool      `json:"previous"`   // A previous version is available for a rollback
+}
+
+// databases returns the updater of every database by its name. MaxMind
+// and ip2location databases are named after their product id.
+func (s *Server) databases() ([]string, map[string]*updater.Config) {
+	names := []string{s.Config.MMProductID, s.Config.MMASNProductID, s.Config.I2LProductID, "tor"}
+	databases := map[string]*updater.Config{
+		s.Config.MMProductID:    s.Api.db.Updater,
+		s.Config.MMASNProductID: s.Api.asnDB.Updater,
+		s.Config.I2LProductID:   s.Api.i2lDB.Updater,
+		"tor":                   s.Api.torDB.Updater,
+	}
+	for _, edition := range s.Api.editions {
+		names = append(names, edition.ProductID())
+		databases[edition.ProductID()] = edition.Updater
+	}
+	return names, databases
+}
+
+func databaseRecord(name string, u *updater.Config) *DatabaseRecord {
+	mode := u.Mode
+	if mode == "" {
+		mode = updater.ModeURL
+	}
+	return &DatabaseRecord{
+		Name:       name,
+		Mode:       mode,
+		File:       u.File,
+		Updated:    u.Date(),
+		Downloaded: u.DownloadDate(),
+		Previous:   mode == updater.ModeURL && u.HasPrevious(),
+	}
+}
+
+// Databases writes the state of all databases.
+func (s *Server) Databases(w http.ResponseWriter, r *http.Request) {
+	names, databases := s.databases()
+	records := []*DatabaseRecord{}
+	for _, name := range names {
+		records = append(records, databaseRecord(name, databases[name]))
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(records)
+}
+
+// RollbackDatabase restores the previous version of the database given by
+// the database parameter.
+func (s *Server) RollbackDatabase(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("database")
+	_, databases := s.databases()
+	u, ok := databases[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := u.Rollback(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(databaseRecord(name, u))
+}
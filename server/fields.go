@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Fields holds a list of dotted json paths such as "location.country.code"
+// used to trim the response. An empty list selects all fields.
+type Fields []string
+
+// fieldTree is the nested form of Fields. A nil subtree selects the whole
+// value below that node.
+type fieldTree map[string]fieldTree
+
+type selectedField struct {
+	json  string
+	xml   string
+	value interface{}
+}
+
+// selection is an ordered subset of a struct which can be encoded as json
+// and xml.
+type selection []selectedField
+
+// countryDetailFields returns all fields provided by the gountries
+// enrichment.
+func countryDetailFields() []string {
+	paths := []string{"network.tld"}
+	t := reflect.TypeOf(CountryRecord{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonFieldName(t.Field(i)); ok && name != "code" {
+			paths = append(paths, "location.country."+name)
+		}
+	}
+	return paths
+}
+
+// getRequestFields parses and validates the fields parameter.
+func getRequestFields(r *http.Request) (Fields, error) {
+	var fields Fields
+	for _, param := range r.URL.Query()["fields"] {
+		for _, path := range strings.Split(param, ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			if !validFieldPath(reflect.TypeOf(ResponseRecord{}), strings.Split(path, ".")) {
+				return nil, fmt.Errorf("unknown field: %s", path)
+			}
+			fields = append(fields, path)
+		}
+	}
+	return fields, nil
+}
+
+// Needs reports whether any of the given paths is selected. A path counts
+// as selected if it is part of, or contains, a requested field.
+func (f Fields) Needs(paths ...string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, field := range f {
+		for _, path := range paths {
+			if field == path || strings.HasPrefix(field, path+".") || strings.HasPrefix(path, field+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f Fields) tree() fieldTree {
+	tree := fieldTree{}
+	for _, field := range f {
+		node := tree
+		parts := strings.Split(field, ".")
+		for i, part := range parts {
+			sub, exists := node[part]
+			if exists && sub == nil {
+				// A parent path has already been selected completely
+				break
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if !exists {
+				sub = fieldTree{}
+				node[part] = sub
+			}
+			node = sub
+		}
+	}
+	return tree
+}
+
+func validFieldPath(t reflect.Type, parts []string) bool {
+	if len(parts) == 0 {
+		return true
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonFieldName(t.Field(i)); ok && name == parts[0] {
+			return validFieldPath(t.Field(i).Type, parts[1:])
+		}
+	}
+	return false
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" || f.Name == "XMLName" {
+		return "", false
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
+
+// selectFields returns the parts of v which are part of the given tree.
+func selectFields(v reflect.Value, tree fieldTree) interface{} {
+	if tree == nil {
+		return v.Interface()
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = selectFields(v.Index(i), tree)
+		}
+		return list
+	case reflect.Struct:
+		s := selection{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := jsonFieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			sub, selected := tree[name]
+			if !selected {
+				continue
+			}
+			value := selectFields(v.Field(i), sub)
+			if value == nil {
+				continue
+			}
+			s = append(s, selectedField{json: name, xml: t.Field(i).Name, value: value})
+		}
+		return s
+	}
+	return v.Interface()
+}
+
+func (s selection) MarshalJSON() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for i, f := range s {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.json)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func (s selection) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, f := range s {
+		if err := e.EncodeElement(f.value, xml.StartElement{Name: xml.Name{Local: f.xml}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// csvFields returns one csv column for every leaf below the given path.
+// Values of nested lists are joined by "/".
+func csvFields(v reflect.Value, t reflect.Type, parts []string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsValid() {
+			v = v.Elem()
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var columns []string
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := jsonFieldName(t.Field(i))
+			if !ok || (len(parts) > 0 && parts[0] != name) {
+				continue
+			}
+			var field reflect.Value
+			if v.IsValid() {
+				field = v.Field(i)
+			}
+			if len(parts) > 0 {
+				return csvFields(field, t.Field(i).Type, parts[1:])
+			}
+			columns = append(columns, csvFields(field, t.Field(i).Type, nil)...)
+		}
+		return columns
+	case reflect.Slice:
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct && len(parts) == 0 {
+			break
+		}
+		columns := csvFields(reflect.Value{}, t.Elem(), parts)
+		values := make([][]string, len(columns))
+		for i := 0; v.IsValid() && i < v.Len(); i++ {
+			for j, value := range csvFields(v.Index(i), t.Elem(), parts) {
+				values[j] = append(values[j], value)
+			}
+		}
+		for i := range columns {
+			columns[i] = strings.Join(values[i], "/")
+		}
+		return columns
+	}
+
+	return []string{csvValue(v)}
+}
+
+func csvValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', 4, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Slice:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = csvValue(v.Index(i))
+		}
+		return strings.Join(values, "/")
+	}
+	return v.String()
+}
+
+type responseRecord ResponseRecord
+
+func (rr *ResponseRecord) MarshalJSON() ([]byte, error) {
+	if len(rr.fields) == 0 {
+		return json.Marshal((*responseRecord)(rr))
+	}
+	return json.Marshal(selectFields(reflect.ValueOf(rr), rr.fields.tree()))
+}
+
+func (rr *ResponseRecord) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "Response"}
+	if len(rr.fields) == 0 {
+		return e.EncodeElement((*responseRecord)(rr), start)
+	}
+	return e.EncodeElement(selectFields(reflect.ValueOf(rr), rr.fields.tree()), start)
+}
+
+// csvColumns returns the csv columns of all selected fields in the
+// requested order.
+func (rr *ResponseRecord) csvColumns() []string {
+	if len(rr.fields) == 0 {
+		return rr.csvRow()
+	}
+	var columns []string
+	for _, field := range rr.fields {
+		columns = append(columns, csvFields(reflect.ValueOf(rr), reflect.TypeOf(rr), strings.Split(field, "."))...)
+	}
+	return columns
+}
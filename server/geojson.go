@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+)
+
+const (
+	geoJSONContentType = "application/geo+json"
+	// Mean earth radius in km, the unit of the accuracy radius
+	earthRadius = 6371.0088
+	// Number of vertices used to approximate the accuracy radius circle
+	accuracyVertices = 32
+)
+
+// GeoJSONFeature is a GeoJSON Feature carrying the response record as
+// properties.
+type GeoJSONFeature struct {
+	Type       string           `json:"type"`
+	ID         string           `json:"id,omitempty"`
+	Geometry   *GeoJSONGeometry `json:"geometry"`
+	Properties interface{}      `json:"properties"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string             `json:"type"`
+	Coordinates interface{}        `json:"coordinates,omitempty"`
+	Geometries  []*GeoJSONGeometry `json:"geometries,omitempty"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*GeoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature converts a record into a feature. The geometry is a Point
+// or, if accuracy is set, a GeometryCollection of the Point and a Polygon
+// approximating the accuracy radius. Records without coordinates have no
+// geometry.
+func geoJSONFeature(d *ResponseRecord, accuracy bool) *GeoJSONFeature {
+	feature := &GeoJSONFeature{Type: "Feature", Properties: d}
+	l := d.Location
+	if l == nil || !d.fields.Needs("location.latitude", "location.longitude") || !l.located {
+		return feature
+	}
+
+	feature.Geometry = &GeoJSONGeometry{
+		Type:        "Point",
+		Coordinates: []float64{roundCoordinate(l.Longitude), roundCoordinate(l.Latitude)},
+	}
+	if accuracy && l.AccuracyRadius > 0 {
+		feature.Geometry = &GeoJSONGeometry{
+			Type:       "GeometryCollection",
+			Geometries: []*GeoJSONGeometry{feature.Geometry, accuracyGeometry(l.Latitude, l.Longitude, float64(l.AccuracyRadius))},
+		}
+	}
+	return feature
+}
+
+// geoJSONBatchFeature converts a batch record into a feature identified by
+// the query. Failed lookups only carry the error as property.
+func geoJSONBatchFeature(d *BatchRecord, accuracy bool) *GeoJSONFeature {
+	if d.Record == nil {
+		return &GeoJSONFeature{Type: "Feature", ID: d.Query, Properties: map[string]string{"error": d.Error}}
+	}
+	feature := geoJSONFeature(d.Record, accuracy)
+	feature.ID = d.Query
+	return feature
+}
+
+// accuracyGeometry returns a Polygon approximating the accuracy radius
+// around the center. Circles crossing the antimeridian are split into a
+// MultiPolygon as required by RFC 7946 section 3.1.9, circles covering a
+// pole are replaced by their bounding box.
+func accuracyGeometry(lat, lon, radius float64) *GeoJSONGeometry {
+	phi, delta := lat*math.Pi/180, radius/earthRadius
+	if phi+delta >= math.Pi/2 || phi-delta <= -math.Pi/2 {
+		south, north := (phi-delta)*180/math.Pi, 90.0
+		if phi < 0 {
+			south, north = -90.0, (phi+delta)*180/math.Pi
+		}
+		south, north = roundCoordinate(math.Max(south, -90)), roundCoordinate(math.Min(north, 90))
+		return &GeoJSONGeometry{
+			Type:        "Polygon",
+			Coordinates: [][][]float64{{{-180, south}, {180, south}, {180, north}, {-180, north}, {-180, south}}},
+		}
+	}
+
+	ring := accuracyRing(lat, lon, radius)
+	shift := 0.0
+	for _, p := range ring {
+		if p[0] > 180 {
+			shift = -360
+		} else if p[0] < -180 {
+			shift = 360
+		}
+	}
+	if shift == 0 {
+		return &GeoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{roundRing(ring)}}
+	}
+
+	shifted := make([][]float64, len(ring))
+	for i, p := range ring {
+		shifted[i] = []float64{p[0] + shift, p[1]}
+	}
+	return &GeoJSONGeometry{
+		Type: "MultiPolygon",
+		Coordinates: [][][][]float64{
+			{roundRing(clipRing(ring))},
+			{roundRing(clipRing(shifted))},
+		},
+	}
+}
+
+// accuracyRing returns a closed counterclockwise ring of points with the
+// given distance in km around the center. The longitudes are continuous
+// and exceed ±180 if the ring crosses the antimeridian.
+func accuracyRing(lat, lon, radius float64) [][]float64 {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	delta := radius / earthRadius
+
+	ring := make([][]float64, 0, accuracyVertices+1)
+	for i := 0; i < accuracyVertices; i++ {
+		theta := -2 * math.Pi * float64(i) / accuracyVertices
+		phi2 := math.Asin(math.Sin(phi)*math.Cos(delta) + math.Cos(phi)*math.Sin(delta)*math.Cos(theta))
+		lambda2 := lambda + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(phi), math.Cos(delta)-math.Sin(phi)*math.Sin(phi2))
+		ring = append(ring, []float64{lambda2 * 180 / math.Pi, phi2 * 180 / math.Pi})
+	}
+	return append(ring, ring[0])
+}
+
+// clipRing cuts off all parts of a closed ring outside of the longitudes
+// -180 to 180.
+func clipRing(ring [][]float64) [][]float64 {
+	ring = clipLongitude(ring, -180, func(lon float64) bool { return lon >= -180 })
+	ring = clipLongitude(ring, 180, func(lon float64) bool { return lon <= 180 })
+	if len(ring) > 0 {
+		ring = append(ring, ring[0])
+	}
+	return ring
+}
+
+// clipLongitude keeps the points of a closed ring which are inside and adds
+// the intersections with the given meridian. The result is not closed.
+func clipLongitude(ring [][]float64, meridian float64, inside func(float64) bool) [][]float64 {
+	if n := len(ring); n > 1 && ring[0][0] == ring[n-1][0] && ring[0][1] == ring[n-1][1] {
+		ring = ring[:n-1]
+	}
+	var result [][]float64
+	for i, p := range ring {
+		prev := ring[(i+len(ring)-1)%len(ring)]
+		if inside(p[0]) != inside(prev[0]) {
+			t := (meridian - prev[0]) / (p[0] - prev[0])
+			result = append(result, []float64{meridian, prev[1] + t*(p[1]-prev[1])})
+		}
+		if inside(p[0]) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func roundRing(ring [][]float64) [][]float64 {
+	result := make([][]float64, len(ring))
+	for i, p := range ring {
+		result[i] = []float64{roundCoordinate(p[0]), roundCoordinate(p[1])}
+	}
+	return result
+}
+
+// roundCoordinate rounds to four decimal places, which equals roughly 11m.
+func roundCoordinate(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+func accuracyRequested(r *http.Request) bool {
+	return len(r.URL.Query()["accuracy"]) > 0
+}
+
+func geoJSONResponse(w http.ResponseWriter, r *http.Request, d *ResponseRecord) {
+	w.Header().Set("Content-Type", geoJSONContentType)
+	if err := json.NewEncoder(w).Encode(geoJSONFeature(d, accuracyRequested(r))); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+func geoJSONBatchResponse(w http.ResponseWriter, r *http.Request, d []*BatchRecord) {
+	collection := &GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]*GeoJSONFeature, len(d))}
+	for i, record := range d {
+		collection.Features[i] = geoJSONBatchFeature(record, accuracyRequested(r))
+	}
+
+	w.Header().Set("Content-Type", geoJSONContentType)
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+// geoJSONBulkResponse writes a single feature of the streamed
+// FeatureCollection, see geoJSONBulkFormat.
+func geoJSONBulkResponse(w http.ResponseWriter, r *http.Request, d *BatchRecord) error {
+	b, err := json.Marshal(geoJSONBatchFeature(d, accuracyRequested(r)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// initGraphQL builds the GraphQL schema. The object types are generated
+// from the api response types, their fields match the json names.
+func (s *Server) initGraphQL() error {
+	objects := map[reflect.Type]*graphql.Object{}
+	lookupArgs := graphql.FieldConfigArgument{
+		"lang": &graphql.ArgumentConfig{Type: graphql.String},
+		"user": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"lookup": &graphql.Field{
+					Type:        graphQLType(reflect.TypeOf(&ResponseRecord{}), objects),
+					Description: "Lookup an ip or hostname. The client ip is used if host is omitted.",
+					Args: mergeGraphQLArgs(lookupArgs, graphql.FieldConfigArgument{
+						"host": &graphql.ArgumentConfig{Type: graphql.String},
+					}),
+					Resolve: s.resolveLookup,
+				},
+				"lookups": &graphql.Field{
+					Type:        graphQLType(reflect.TypeOf([]*BatchRecord{}), objects),
+					Description: "Lookup a list of ips or hostnames.",
+					Args: mergeGraphQLArgs(lookupArgs, graphql.FieldConfigArgument{
+						"hosts": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+					}),
+					Resolve: s.resolveLookups,
+				},
+			},
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Api.graphql = &schema
+	return nil
+}
+
+// maxGraphQLBodySize limits the size of a json encoded request body.
+const maxGraphQLBodySize = 1 << 20
+
+// GraphQL executes GraphQL queries provided either as query parameters or
+// as json encoded request body.
+func (s *Server) GraphQL(w http.ResponseWriter, r *http.Request) {
+	req := &graphQLRequest{}
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLBodySize)
+		err := json.NewDecoder(r.Body).Decode(req)
+		if _, ok := err.(*http.MaxBytesError); ok {
+			http.Error(w, fmt.Sprintf("Request body exceeds the limit of %d bytes.", maxGraphQLBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         *s.Api.graphql,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		RootObject:     map[string]interface{}{"request": r, "lookups": new(int32)},
+		Context:        r.Context(),
+	})
+
+	w.Header().Set("X-Database-Date", s.Api.db.Updater.Date().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) resolveLookup(p graphql.ResolveParams) (interface{}, error) {
+	r := s.graphQLLookupRequest(p)
+	orig := p.Info.RootValue.(map[string]interface{})["request"].(*http.Request)
+	host, _ := p.Args["host"].(string)
+	if host == "" {
+		host = remoteHost(orig)
+	}
+	if err := s.takeQuota(nil, orig, s.graphQLCost(p, []string{host})); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.lookupHost(host, graphQLFields(p, ""), r)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *Server) resolveLookups(p graphql.ResolveParams) (interface{}, error) {
+	var hosts []string
+	for _, host := range p.Args["hosts"].([]interface{}) {
+		hosts = append(hosts, host.(string))
+	}
+	if s.Config.BatchLimit > 0 && len(hosts) > s.Config.BatchLimit {
+		return nil, fmt.Errorf("batch size exceeds the limit of %d entries", s.Config.BatchLimit)
+	}
+
+	orig := p.Info.RootValue.(map[string]interface{})["request"].(*http.Request)
+	if err := s.takeQuota(nil, orig, s.graphQLCost(p, hosts)); err != nil {
+		return nil, err
+	}
+
+	return s.lookupHosts(hosts, graphQLFields(p, "record"), s.graphQLLookupRequest(p)), nil
+}
+
+// graphQLCost returns the cost of looking up the given hosts. The request
+// itself already accounted for a single lookup, which is only deducted
+// for the first lookup field of the query, aliased fields pay in full.
+func (s *Server) graphQLCost(p graphql.ResolveParams, hosts []string) int {
+	root := p.Info.RootValue.(map[string]interface{})
+	orig := root["request"].(*http.Request)
+	if atomic.AddInt32(root["lookups"].(*int32), 1) == 1 {
+		return s.batchCost(orig, hosts)
+	}
+	cost := 0
+	for _, host := range hosts {
+		cost += s.entryCost(orig, host)
+	}
+	return cost
+}
+
+// graphQLLookupRequest creates the request used by the lookup pipeline from
+// the original request headers and the query arguments.
+func (s *Server) graphQLLookupRequest(p graphql.ResolveParams) *http.Request {
+	r := p.Info.RootValue.(map[string]interface{})["request"].(*http.Request)
+	lang, _ := p.Args["lang"].(string)
+	if lang == "" {
+		lang = r.Header.Get("Accept-Language")
+	}
+	user, _ := p.Args["user"].(bool)
+	return lookupRequest(p.Context, r.Header, lang, user)
+}
+
+// graphQLFields translates the selection set of the current field into
+// Fields, which allows the lookup to skip all databases that are not
+// required. Only paths below prefix are taken into account.
+func graphQLFields(p graphql.ResolveParams, prefix string) Fields {
+	var fields Fields
+	for _, field := range p.Info.FieldASTs {
+		for _, path := range graphQLSelection(field.SelectionSet, "", p.Info.Fragments) {
+			if prefix == "" {
+				fields = append(fields, path)
+			} else if strings.HasPrefix(path, prefix+".") {
+				fields = append(fields, strings.TrimPrefix(path, prefix+"."))
+			}
+		}
+	}
+	if len(fields) == 0 {
+		// An empty list selects everything
+		fields = Fields{"network.ip"}
+	}
+	return fields
+}
+
+func graphQLSelection(set *ast.SelectionSet, prefix string, fragments map[string]ast.Definition) []string {
+	if set == nil {
+		return nil
+	}
+	var paths []string
+	for _, selection := range set.Selections {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			name := sel.Name.Value
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+			if sel.SelectionSet == nil {
+				paths = append(paths, prefix+name)
+				continue
+			}
+			paths = append(paths, graphQLSelection(sel.SelectionSet, prefix+name+".", fragments)...)
+		case *ast.InlineFragment:
+			paths = append(paths, graphQLSelection(sel.SelectionSet, prefix, fragments)...)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[sel.Name.Value].(*ast.FragmentDefinition); ok {
+				paths = append(paths, graphQLSelection(fragment.SelectionSet, prefix, fragments)...)
+			}
+		}
+	}
+	return paths
+}
+
+func mergeGraphQLArgs(args ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	merged := graphql.FieldConfigArgument{}
+	for _, a := range args {
+		for name, arg := range a {
+			merged[name] = arg
+		}
+	}
+	return merged
+}
+
+// graphQLType returns the GraphQL output type of the given type. Structs
+// are turned into objects with one field per json field.
+func graphQLType(t reflect.Type, objects map[reflect.Type]*graphql.Object) graphql.Output {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16:
+		return graphql.Int
+	// Int is a signed 32 bit integer, larger values would turn into null
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return graphql.Float
+	case reflect.Slice:
+		return graphql.NewList(graphQLType(t.Elem(), objects))
+	case reflect.Struct:
+		if obj, exists := objects[t]; exists {
+			return obj
+		}
+		fields := graphql.Fields{}
+		objects[t] = graphql.NewObject(graphql.ObjectConfig{
+			Name:   t.Name(),
+			Fields: graphql.FieldsThunk(func() graphql.Fields { return fields }),
+		})
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := jsonFieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			index := i
+			fields[name] = &graphql.Field{
+				Type: graphQLType(t.Field(i).Type, objects),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					v := reflect.ValueOf(p.Source)
+					for v.Kind() == reflect.Ptr {
+						if v.IsNil() {
+							return nil, nil
+						}
+						v = v.Elem()
+					}
+					field := v.Field(index)
+					if field.Kind() == reflect.Ptr && field.IsNil() {
+						return nil, nil
+					}
+					return field.Interface(), nil
+				},
+			}
+		}
+		return objects[t]
+	}
+	return graphql.String
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const grpcService = `
+service GeoIP {
+  rpc Lookup(LookupRequest) returns (ResponseRecord);
+  rpc LookupStream(stream LookupRequest) returns (stream BatchRecord);
+}
+`
+
+// geoIPService is the gRPC counterpart of the json endpoints.
+type geoIPService interface {
+	Lookup(ctx context.Context, req *LookupRequest) (*ResponseRecord, error)
+	LookupStream(stream grpc.ServerStream) error
+}
+
+var geoIPServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".GeoIP",
+	HandlerType: (*geoIPService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &LookupRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(geoIPService).Lookup(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + protoPackage + ".GeoIP/Lookup"}
+				return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(geoIPService).Lookup(ctx, req.(*LookupRequest))
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "LookupStream",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(geoIPService).LookupStream(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "geoip.proto",
+}
+
+// protoCodec encodes the api types using the reflection based protobuf
+// encoding. It replaces the default codec which requires generated code.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error)      { return marshalProto(v) }
+func (protoCodec) Unmarshal(data []byte, v interface{}) error { return unmarshalProto(data, v) }
+func (protoCodec) Name() string                               { return "proto" }
+
+// Lookup resolves a single ip or hostname.
+func (s *Server) Lookup(ctx context.Context, req *LookupRequest) (*ResponseRecord, error) {
+	resp, err := s.lookupHost(req.Host, nil, grpcRequest(ctx, req))
+	if err == ErrHostNotFound {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return resp, nil
+}
+
+// LookupStream resolves every received ip or hostname and sends back one
+// record per request in the same order.
+func (s *Server) LookupStream(stream grpc.ServerStream) error {
+	for {
+		req := &LookupRequest{}
+		if err := stream.RecvMsg(req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		record := &BatchRecord{Query: req.Host}
+		if resp, err := s.lookupHost(req.Host, nil, grpcRequest(stream.Context(), req)); err != nil {
+			record.Error = err.Error()
+		} else {
+			record.Record = resp
+		}
+		if err := stream.SendMsg(record); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcRequest translates a gRPC request into the http request expected by
+// the lookup pipeline. The user agent and language are taken from the
+// request metadata.
+func grpcRequest(ctx context.Context, req *LookupRequest) *http.Request {
+	header := http.Header{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{"user-agent", "accept-language"} {
+			if values := md.Get(key); len(values) > 0 {
+				header.Set(key, values[0])
+			}
+		}
+	}
+	return lookupRequest(ctx, header, req.Lang, req.User)
+}
+
+// grpcQuota resolves the quota of a gRPC call like the rate limit middleware
+// does for http requests. The client is identified by its peer address and
+// the api key is read from the x-api-key metadata.
+func (s *Server) grpcQuota(ctx context.Context, method string) (*requestQuota, error) {
+	r := &http.Request{URL: &url.URL{Path: method}, Header: http.Header{}}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			r.Header.Set("X-API-Key", values[0])
+		}
+	}
+
+	if s.AccessList != nil && s.AccessList.Denied(net.ParseIP(remoteHost(r))) {
+		return nil, status.Error(codes.PermissionDenied, strings.ToLower(http.StatusText(http.StatusForbidden)))
+	}
+	if s.RateLimit == nil {
+		return nil, nil
+	}
+	rq, code := s.resolveQuota(r)
+	switch code {
+	case http.StatusUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, strings.ToLower(http.StatusText(code)))
+	case http.StatusForbidden:
+		return nil, status.Error(codes.PermissionDenied, strings.ToLower(http.StatusText(code)))
+	}
+	rq.cost = 1
+	return rq, nil
+}
+
+// grpcUnaryInterceptor charges every unary call to the quota of the client.
+func (s *Server) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	rq, err := s.grpcQuota(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if rq != nil && rq.policy.Limit > 0 {
+		cost := rq.cost
+		if lr, ok := req.(*LookupRequest); ok {
+			cost += s.hostCost(lr.Host)
+		}
+		q := s.RateLimit.TakeN(rq.bucket, cost, rq.policy)
+		if rq.apiKey != nil {
+			s.APIKeys.count(rq.apiKey, !q.Allowed)
+		}
+		if !q.Allowed {
+			return nil, status.Error(codes.ResourceExhausted, (&quotaError{n: cost, quota: q}).Error())
+		}
+	}
+	return handler(ctx, req)
+}
+
+// grpcStreamInterceptor resolves the quota of the client once per stream.
+// Like the bulk endpoints every received message waits until the quota
+// allows the next lookup.
+func (s *Server) grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	rq, err := s.grpcQuota(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if rq != nil && rq.policy.Limit > 0 {
+		if rq.apiKey != nil {
+			s.APIKeys.count(rq.apiKey, false)
+		}
+		stream = &quotaStream{ServerStream: stream, s: s, rq: rq}
+	}
+	return handler(srv, stream)
+}
+
+// quotaStream charges every received lookup request to a quota.
+type quotaStream struct {
+	grpc.ServerStream
+	s  *Server
+	rq *requestQuota
+}
+
+func (qs *quotaStream) RecvMsg(m interface{}) error {
+	if err := qs.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	cost := qs.rq.cost
+	if lr, ok := m.(*LookupRequest); ok {
+		cost += qs.s.hostCost(lr.Host)
+	}
+	if err := qs.s.RateLimit.Wait(qs.Context(), qs.rq.bucket, cost, qs.rq.policy); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
+
+func (s *Server) runGRPCServer() {
+	if !s.Config.Silent {
+		log.Println("geoip grpc server starting on", s.Config.GRPCServerAddr)
+	}
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(protoCodec{}),
+		grpc.UnaryInterceptor(s.grpcUnaryInterceptor),
+		grpc.StreamInterceptor(s.grpcStreamInterceptor),
+	}
+	if s.Config.GRPCTLS {
+		creds, err := credentials.NewServerTLSFromFile(s.Config.TLSCertFile, s.Config.TLSKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	ln, err := net.Listen("tcp", s.Config.GRPCServerAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&geoIPServiceDesc, s)
+	s.Mutex.Lock()
+	s.grpcServer = srv
+	s.Mutex.Unlock()
+	// Serve returns nil once the server has been stopped
+	if err := srv.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// protoSchemaResponse serves the protobuf schema of all messages and the
+// gRPC service.
+func (s *Server) protoSchemaResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.WriteString(w, protoSchema(grpcService, &LookupRequest{}, &ResponseRecord{}, &BatchRecord{})); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
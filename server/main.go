@@ -4,18 +4,29 @@ import (
 	"../utils/config"
 	"../utils/i2ldb"
 	"../utils/mmdb"
+	"../utils/override"
 	"../utils/tor"
 	"../utils/updater"
 	"encoding/xml"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"google.golang.org/grpc"
 	"github.com/rs/cors"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 )
 
+var (
+	// ErrHostNotFound is returned if a host could not be resolved.
+	ErrHostNotFound = errors.New("host not found")
+	// ErrUnavailable is returned if a database lookup failed.
+	ErrUnavailable = errors.New("database unavailable")
+)
 
 // Create a custom visitor struct which holds the rate limiter for each
 // visitor and the last time that the visitor was seen.
@@ -26,9 +37,17 @@ type Server struct {
 	Host string
 	Port int
 
-	RateLimit *RateLimit
+	RateLimit Limiter
+	APIKeys   *APIKeyStore
+	AccessList *AccessList
+	policies  []*quotaPolicy
+	policyFields Fields
+	policyCache *policyCache
 	Visitors map[string]*Visitor
-	Mutex sync.Mutex
+	Mutex sync.Mutex // Guards the running servers
+
+	httpServers []*http.Server
+	grpcServer  *grpc.Server
 
 	Api *ApiHandler
 }
@@ -38,6 +57,8 @@ type GeoIpQuery struct {
 	mmdb.DefaultQuery
 	mmdb.ASNDefaultQuery
 	mmdb.TorDefaultQuery
+
+	fields Fields
 }
 
 type ResponseRecord struct {
@@ -46,6 +67,21 @@ type ResponseRecord struct {
 	Location	*LocationRecord `json:"location"`
 	System		*SystemRecord   `json:"system,omitempty"`
 	User		*UserRecord 	`json:"user,omitempty"`
+	Source		string			`json:"source,omitempty" xml:",omitempty"`
+	Override	*OverrideRecord	`json:"override,omitempty"`
+
+	fields		Fields
+}
+
+type OverrideRecord struct {
+	Network 	string			`json:"network"`
+	Tags 		[]string		`json:"tags"`
+	Labels 		[]*LabelRecord	`json:"labels"`
+}
+
+type LabelRecord struct {
+	Name 		string  	`json:"name"`
+	Value 		string  	`json:"value"`
 }
 
 type LocationRecord struct {
@@ -59,6 +95,8 @@ type LocationRecord struct {
 	AccuracyRadius   	uint  			`json:"accuracy_radius"`
 	MetroCode   		uint    		`json:"metro_code"`
 	Country				*CountryRecord 	`json:"country"`
+
+	located bool // Coordinates are known, 0,0 is a valid location
 }
 
 type CountryRecord struct {
@@ -138,7 +176,11 @@ type ApiHandler struct {
 	asnDB *mmdb.DB
 	torDB *tor.Config
 	i2lDB *i2ldb.Config
-	cors  *cors.Cors
+	overrideDB *override.Config
+	editions []*mmdb.DB // Additional MaxMind editions, downloaded but not used for lookups
+	cors    *cors.Cors
+	graphql *graphql.Schema
+	openapi []byte // Rendered OpenAPI document
 }
 
 func NewServerConfig(c *config.Config) *Server {
@@ -157,16 +199,26 @@ func NewServerConfig(c *config.Config) *Server {
 		Host: host,
 		Port: port,
 
-		RateLimit: NewRateLimit(c.RateLimitLimit, c.RateLimitBurst, c.RateLimitInterval),
-
 		Api: &ApiHandler{
-			db:    mmdb.NewDefaultConfig(c, c.MMProductID),
-			asnDB: mmdb.NewDefaultConfig(c, c.MMASNProductID),
+			db:    mmdb.NewDefaultConfig(c, c.MMProductID, c.MMFile),
+			asnDB: mmdb.NewDefaultConfig(c, c.MMASNProductID, c.MMASNFile),
 			torDB: tor.NewDefaultConfig(c),
 			i2lDB: i2ldb.NewDefaultConfig(c),
 		},
 	}
 
+	if editions := c.Editions(); c.MMMode == updater.ModeURL {
+		for _, id := range editions {
+			conf.Api.editions = append(conf.Api.editions, mmdb.NewDefaultConfig(c, id, ""))
+		}
+	} else if len(editions) > 0 && !c.Silent {
+		log.Printf("MaxMind editions %s skipped, they are only downloaded with MM_MODE url", strings.Join(editions, ", "))
+	}
+
+	if c.OverrideFile != "" {
+		conf.Api.overrideDB = override.NewDefaultConfig(c)
+	}
+
 	c.LogOutput = os.Stdout
 
 	if c.LogToStdout {
@@ -189,8 +241,16 @@ func (s *Server) Start() {
 	go s.watchEvents(s.Api.db.Updater)
 	go s.watchEvents(s.Api.asnDB.Updater)
 	go s.watchEvents(s.Api.i2lDB.Updater)
+	for _, edition := range s.Api.editions {
+		go s.watchEvents(edition.Updater)
+	}
+	if s.Api.overrideDB != nil {
+		go s.watchEvents(s.Api.overrideDB.Updater)
+	}
 
-	_ = s.openDB()
+	if err := s.openDB(); err != nil && !s.Config.Silent {
+		log.Println("database error:", err)
+	}
 	f, err := s.NewHandler()
 	if err != nil {
 		log.Fatal(err)
@@ -201,5 +261,8 @@ func (s *Server) Start() {
 	if s.Config.TLSServerAddr != "" {
 		go s.runTLSServer(f)
 	}
-	select {}
+	if s.Config.GRPCServerAddr != "" {
+		go s.runGRPCServer()
+	}
+	s.waitForShutdown()
 }
\ No newline at end of file
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputFormat connects a writer with the media types it produces. The
+// first media type is the canonical one.
+type outputFormat struct {
+	name       string
+	mediaTypes []string
+	writer     writerFunc
+}
+
+// outputFormats lists all formats available through content negotiation
+// in order of preference.
+var outputFormats = []*outputFormat{
+	{"json", []string{"application/json"}, jsonResponse},
+	{"xml", []string{"application/xml", "text/xml"}, xmlResponse},
+	{"csv", []string{"text/csv"}, csvResponse},
+	{"msgpack", []string{"application/msgpack", "application/x-msgpack"}, msgpackResponse},
+	{"cbor", []string{"application/cbor"}, cborResponse},
+	{"proto", []string{"application/x-protobuf", "application/protobuf"}, protoResponse},
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// NegotiatedLookUp selects the output format from the format parameter or
+// the Accept header and dispatches the request to the matching writer.
+func (s *Server) NegotiatedLookUp() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		var format *outputFormat
+		if name := r.URL.Query().Get("format"); name != "" {
+			format = formatByName(name)
+		} else {
+			format = negotiateFormat(r.Header.Get("Accept"))
+		}
+		if format == nil {
+			http.Error(w, fmt.Sprintf("%s. Supported media types: %s", http.StatusText(http.StatusNotAcceptable), strings.Join(supportedMediaTypes(), ", ")), http.StatusNotAcceptable)
+			return
+		}
+
+		s.IpLookUp(format.writer)(w, r)
+	}
+}
+
+func formatByName(name string) *outputFormat {
+	for _, f := range outputFormats {
+		if strings.EqualFold(f.name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// negotiateFormat returns the format which matches the Accept header best
+// or nil if none is acceptable. An empty header selects json.
+func negotiateFormat(accept string) *outputFormat {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return outputFormats[0]
+	}
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, f := range outputFormats {
+			for _, mediaType := range f.mediaTypes {
+				if matchMediaType(ar.mediaType, mediaType) && acceptQuality(ranges, mediaType) > 0 {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseAccept parses the Accept header and sorts the media ranges by
+// their quality. Ranges of the same quality keep their order.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		ar := acceptRange{mediaType: mediaType, q: 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// acceptQuality returns the quality of the most specific range matching
+// the given media type.
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, ar := range ranges {
+		if !matchMediaType(ar.mediaType, mediaType) {
+			continue
+		}
+		s := 2
+		if ar.mediaType == "*/*" {
+			s = 0
+		} else if strings.HasSuffix(ar.mediaType, "/*") {
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+func supportedMediaTypes() []string {
+	var mediaTypes []string
+	for _, f := range outputFormats {
+		mediaTypes = append(mediaTypes, f.mediaTypes...)
+	}
+	return mediaTypes
+}
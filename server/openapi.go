@@ -0,0 +1,271 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// queryParams holds the OpenAPI description of all known query parameters.
+var queryParams = map[string]map[string]interface{}{
+	"lang": {
+		"description": "Two letter language code used for translated names. Defaults to the Accept-Language header.",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"user": {
+		"description":     "Include system and user information derived from the request headers.",
+		"allowEmptyValue": true,
+		"schema":          map[string]interface{}{"type": "boolean"},
+	},
+	"callback": {
+		"description": "Wrap the json response in a javascript function call (JSONP).",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"fields": {
+		"description": "Comma separated list of dotted json paths used to trim the response.",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"header": {
+		"description": "Prepend a csv header row with the column names.",
+		"schema":      map[string]interface{}{"type": "boolean"},
+	},
+	"database": {
+		"description": "Name of the database, see /admin/databases.",
+		"required":    true,
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"accuracy": {
+		"description":     "Add a polygon approximating the accuracy radius to the geometry.",
+		"allowEmptyValue": true,
+		"schema":          map[string]interface{}{"type": "boolean"},
+	},
+	"format": {
+		"description": "Output format overriding the Accept header. One of json, xml, csv, msgpack, cbor or proto.",
+		"schema":      map[string]interface{}{"type": "string", "enum": []string{"json", "xml", "csv", "msgpack", "cbor", "proto"}},
+	},
+	"query": {
+		"description": "GraphQL query document.",
+		"required":    true,
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"variables": {
+		"description": "Json encoded GraphQL variables.",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"operationName": {
+		"description": "Name of the GraphQL operation to execute.",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+}
+
+// OpenAPI serves the OpenAPI 3 description of all registered routes.
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s.Api.openapi)
+}
+
+// initOpenAPI renders the OpenAPI document of the given routes, which is
+// served unchanged afterwards.
+func (s *Server) initOpenAPI(routes []*route) error {
+	doc, err := json.MarshalIndent(s.openAPIDocument(routes), "", "  ")
+	if err != nil {
+		return err
+	}
+	s.Api.openapi = append(doc, '\n')
+	return nil
+}
+
+func (s *Server) openAPIDocument(routes []*route) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	for _, rt := range routes {
+		for _, path := range openAPIPaths(rt.path) {
+			item, ok := paths[path].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				paths[path] = item
+			}
+			op := rt.operation(path, schemas)
+			s.addErrorResponses(rt, op)
+			item[strings.ToLower(rt.method)] = op
+		}
+	}
+
+	version := s.Config.Build.Version
+	if version == "" {
+		version = "dev"
+	}
+	server := strings.TrimSuffix(s.Config.APIPrefix, "/")
+	if server == "" {
+		server = "/"
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "GoGeoIP",
+			"description": "IP geolocation api",
+			"version":     version,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": server},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	if s.Config.APIKeysFile != "" {
+		schemes := doc["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})
+		schemes["apiKeyHeader"] = map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"}
+		schemes["apiKeyQuery"] = map[string]interface{}{"type": "apiKey", "in": "query", "name": "key"}
+		security := []interface{}{
+			map[string]interface{}{"apiKeyHeader": []string{}},
+			map[string]interface{}{"apiKeyQuery": []string{}},
+		}
+		if !s.Config.APIKeyRequired {
+			// Anonymous requests are allowed as well
+			security = append(security, map[string]interface{}{})
+		}
+		doc["security"] = security
+	}
+	return doc
+}
+
+// addErrorResponses documents the responses of rejected requests, which
+// depend on the configured api keys, quota policies and access list.
+func (s *Server) addErrorResponses(rt *route, op map[string]interface{}) {
+	responses := op["responses"].(map[string]interface{})
+	if s.APIKeys != nil || s.Config.APIKeyRequired {
+		responses["401"] = map[string]interface{}{"description": "Unauthorized"}
+	}
+	if s.APIKeys != nil || s.AccessList != nil || len(s.policies) > 0 {
+		responses["403"] = map[string]interface{}{"description": "Forbidden"}
+	}
+}
+
+// openAPIPaths converts a router path into OpenAPI paths. An optional
+// catch-all parameter results in two paths, one with and one without it.
+func openAPIPaths(path string) []string {
+	i := strings.Index(path, "*")
+	if i < 0 {
+		return []string{path}
+	}
+	return []string{path[:i], path[:i] + "{" + path[i+1:] + "}"}
+}
+
+func (rt *route) operation(path string, schemas map[string]interface{}) map[string]interface{} {
+	var params []interface{}
+	if strings.Contains(path, "{host}") {
+		params = append(params, map[string]interface{}{
+			"name":        "host",
+			"in":          "path",
+			"required":    true,
+			"description": "IP address or hostname. The client ip is used if omitted.",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, name := range rt.params {
+		param := map[string]interface{}{"name": name, "in": "query"}
+		for k, v := range queryParams[name] {
+			param[k] = v
+		}
+		params = append(params, param)
+	}
+
+	content := map[string]interface{}{}
+	for _, m := range rt.response {
+		content[m.contentType] = map[string]interface{}{"schema": schemaOf(reflect.TypeOf(m.value), schemas)}
+	}
+
+	op := map[string]interface{}{
+		"summary": rt.summary,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content":     content,
+			},
+			"400": map[string]interface{}{"description": "Bad Request"},
+			"429": map[string]interface{}{"description": "Too Many Requests"},
+		},
+	}
+	if strings.HasSuffix(rt.path, "*host") {
+		responses := op["responses"].(map[string]interface{})
+		responses["404"] = map[string]interface{}{"description": "Host not found"}
+		responses["503"] = map[string]interface{}{"description": "Database not available"}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.admin {
+		op["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		op["responses"].(map[string]interface{})["401"] = map[string]interface{}{"description": "Unauthorized"}
+	}
+	if rt.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				rt.request.contentType: map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.request.value), schemas)},
+			},
+		}
+	}
+	return op
+}
+
+// schemaOf returns the OpenAPI schema of the given type. Named structs are
+// added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := schemas[t.Name()]; exists {
+			return ref
+		}
+		// Register the name first to support recursive types
+		schemas[t.Name()] = nil
+
+		properties := map[string]interface{}{}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Name == "XMLName" {
+				if name := strings.Split(f.Tag.Get("xml"), ",")[0]; name != "" {
+					schema["xml"] = map[string]interface{}{"name": name}
+				}
+				continue
+			}
+			name, ok := jsonFieldName(f)
+			if !ok {
+				continue
+			}
+			properties[name] = schemaOf(f.Type, schemas)
+		}
+		schemas[t.Name()] = schema
+		return ref
+	}
+
+	return map[string]interface{}{}
+}
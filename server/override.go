@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net"
+	"sort"
+
+	"../utils/override"
+)
+
+// lookupOverride returns the override record of the ip. Values which
+// determine further lookups, such as the country code used for the
+// country details, are applied to the query right away.
+func (s *Server) lookupOverride(ip net.IP, q *GeoIpQuery) *override.Record {
+	if s.Api.overrideDB == nil {
+		return nil
+	}
+	o := s.Api.overrideDB.Lookup(ip)
+	if o == nil {
+		return nil
+	}
+	if o.CountryCode != "" {
+		q.Country.ISOCode = o.CountryCode
+	}
+	return o
+}
+
+// applyOverride replaces the values of the record with all values set by
+// the override record and marks the record as overridden.
+func applyOverride(rr *ResponseRecord, o *override.Record) {
+	l := rr.Location
+	if o.RegionCode != "" {
+		l.RegionCode = o.RegionCode
+	}
+	if o.RegionName != "" {
+		l.RegionName = o.RegionName
+	}
+	if o.City != "" {
+		l.City = o.City
+	}
+	if o.ZipCode != "" {
+		l.ZipCode = o.ZipCode
+	}
+	if o.TimeZone != "" {
+		l.TimeZone = o.TimeZone
+	}
+	if o.Latitude != nil {
+		l.Latitude = roundFloat(*o.Latitude, .5, 4)
+	}
+	if o.Longitude != nil {
+		l.Longitude = roundFloat(*o.Longitude, .5, 4)
+	}
+	if o.Latitude != nil && o.Longitude != nil {
+		l.located = true
+	}
+
+	n := rr.Network
+	if o.ASN != 0 {
+		n.AS.Number = o.ASN
+	}
+	if o.ASName != "" {
+		n.AS.Name = o.ASName
+	}
+	if o.ISP != "" {
+		n.Isp = o.ISP
+	}
+	if o.Domain != "" {
+		n.Domain = o.Domain
+	}
+
+	rr.Source = "override"
+	rr.Override = &OverrideRecord{Network: o.Network, Tags: o.Tags, Labels: []*LabelRecord{}}
+	if rr.Override.Tags == nil {
+		rr.Override.Tags = []string{}
+	}
+	for name, value := range o.Labels {
+		rr.Override.Labels = append(rr.Override.Labels, &LabelRecord{Name: name, Value: value})
+	}
+	sort.Slice(rr.Override.Labels, func(i, j int) bool {
+		return rr.Override.Labels[i].Name < rr.Override.Labels[j].Name
+	})
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The protobuf encoding of the api types is derived from their json fields.
+// Every json field gets the field number of its position within the
+// struct, starting at 1. New fields therefore have to be appended to keep
+// the encoding backwards compatible. The matching schema is generated by
+// protoSchema and served under /geoip.proto.
+
+const protoPackage = "gogeoip"
+
+// LookupRequest is the request message of the gRPC lookup service.
+type LookupRequest struct {
+	Host string `json:"host"`
+	Lang string `json:"lang"`
+	User bool   `json:"user"`
+}
+
+// protoFields returns the indexes of all fields which are part of the
+// protobuf encoding together with their field number.
+func protoFields(t reflect.Type) (indexes []int, names []string) {
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonFieldName(t.Field(i)); ok {
+			indexes = append(indexes, i)
+			names = append(names, name)
+		}
+	}
+	return indexes, names
+}
+
+// marshalProto encodes a pointer to one of the api structs. Records with
+// a field selection only contain the selected fields.
+func marshalProto(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("protobuf: unsupported type %s", rv.Type())
+	}
+	return appendProtoMessage(nil, rv, nil)
+}
+
+// appendProtoMessage encodes all fields of v which are part of the given
+// tree. A nil tree selects all fields.
+func appendProtoMessage(b []byte, v reflect.Value, tree fieldTree) ([]byte, error) {
+	if tree == nil && v.CanAddr() {
+		if rr, ok := v.Addr().Interface().(*ResponseRecord); ok && len(rr.fields) > 0 {
+			tree = rr.fields.tree()
+		}
+	}
+	indexes, names := protoFields(v.Type())
+	for n, i := range indexes {
+		sub, selected := tree[names[n]]
+		if tree != nil && !selected {
+			continue
+		}
+		var err error
+		if b, err = appendProtoField(b, protowire.Number(n+1), v.Field(i), sub); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendProtoField(b []byte, num protowire.Number, v reflect.Value, tree fieldTree) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() > 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v.String())
+		}
+	case reflect.Bool:
+		if v.Bool() {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v.Int()))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.Float() != 0 {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b, nil
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return appendProtoField(b, num, v.Elem(), tree)
+		}
+		msg, err := appendProtoMessage(nil, v.Elem(), tree)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	case reflect.Struct:
+		msg, err := appendProtoMessage(nil, v, tree)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			// Empty strings have to be kept to preserve the list length
+			if elem.Kind() == reflect.String && elem.Len() == 0 {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendString(b, "")
+				continue
+			}
+			var err error
+			if b, err = appendProtoField(b, num, elem, tree); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("protobuf: unsupported type %s", v.Type())
+	}
+	return b, nil
+}
+
+// unmarshalProto decodes b into a pointer to one of the api structs.
+// Unknown fields are skipped.
+func unmarshalProto(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("protobuf: unsupported type %T", v)
+	}
+	return consumeProtoMessage(b, rv.Elem())
+}
+
+func consumeProtoMessage(b []byte, v reflect.Value) error {
+	indexes, _ := protoFields(v.Type())
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if int(num) < 1 || int(num) > len(indexes) {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		field := v.Field(indexes[num-1])
+		if n = consumeProtoField(b, typ, field); n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeProtoField(b []byte, typ protowire.Type, v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Slice:
+		elem := reflect.New(v.Type().Elem()).Elem()
+		n := consumeProtoField(b, typ, elem)
+		if n >= 0 {
+			v.Set(reflect.Append(v, elem))
+		}
+		return n
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return consumeProtoField(b, typ, v.Elem())
+	}
+
+	switch typ {
+	case protowire.VarintType:
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return n
+		}
+		switch v.Kind() {
+		case reflect.Bool:
+			v.SetBool(x != 0)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(int64(x))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v.SetUint(x)
+		}
+		return n
+	case protowire.Fixed64Type:
+		x, n := protowire.ConsumeFixed64(b)
+		if n >= 0 && (v.Kind() == reflect.Float64 || v.Kind() == reflect.Float32) {
+			v.SetFloat(math.Float64frombits(x))
+		}
+		return n
+	case protowire.BytesType:
+		x, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(string(x))
+		case reflect.Struct:
+			if err := consumeProtoMessage(x, v); err != nil {
+				return -1
+			}
+		}
+		return n
+	}
+	return protowire.ConsumeFieldValue(0, typ, b)
+}
+
+// protoSchema generates the proto3 schema of the given messages and all
+// messages they depend on.
+func protoSchema(service string, messages ...interface{}) string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "syntax = \"proto3\";\n\npackage %s;\n", protoPackage)
+
+	seen := map[reflect.Type]bool{}
+	var queue []reflect.Type
+	for _, m := range messages {
+		queue = append(queue, reflect.TypeOf(m))
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		fmt.Fprintf(b, "\nmessage %s {\n", t.Name())
+		indexes, names := protoFields(t)
+		for n, i := range indexes {
+			ft := t.Field(i).Type
+			label := ""
+			if ft.Kind() == reflect.Slice {
+				label = "repeated "
+				ft = ft.Elem()
+			}
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				queue = append(queue, ft)
+			}
+			fmt.Fprintf(b, "  %s%s %s = %d;\n", label, protoType(ft), names[n], n+1)
+		}
+		b.WriteString("}\n")
+	}
+
+	if service != "" {
+		b.WriteString("\n" + strings.TrimSpace(service) + "\n")
+	}
+	return b.String()
+}
+
+func protoType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int64"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint64"
+	case reflect.Float32, reflect.Float64:
+		return "double"
+	}
+	return t.Name()
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requestQuota is the quota a request counts against. It is resolved once
+// by the rate limit middleware and stored in the request context, so that
+// handlers can charge additional requests to the same quota.
+type requestQuota struct {
+	bucket string  // Key of the quota in the rate limit backend
+	policy Policy  // Limiter settings, a limit of 0 disables the quota
+	apiKey *APIKey // Api key of the request, nil for anonymous requests
+	cost   int     // Cost of a single lookup of the route, without hostname resolution
+}
+
+// QuotaRecord describes the remaining quota of a client.
+type QuotaRecord struct {
+	Limited   bool  `json:"limited"`
+	Rate      int   `json:"rate"`      // Requests per second the quota refills with
+	Limit     int   `json:"limit"`     // Max number of requests at once
+	Remaining int   `json:"remaining"` // Number of requests which are allowed now
+	Reset     int64 `json:"reset"`     // Unix timestamp the whole limit is available again
+}
+
+type requestQuotaKey struct{}
+
+// requestQuotaFrom returns the quota resolved by the rate limit middleware
+// or nil if there is none.
+func requestQuotaFrom(r *http.Request) *requestQuota {
+	rq, _ := r.Context().Value(requestQuotaKey{}).(*requestQuota)
+	return rq
+}
+
+// resolveQuota returns the quota of the request. Requests with an api key
+// count against the quota of the key, all others against the quota of
+// their subnet, limited by the first matching quota policy. A status code
+// other than 0 is returned if the request has to be rejected.
+func (s *Server) resolveQuota(r *http.Request) (*requestQuota, int) {
+	if s.AccessList != nil && s.AccessList.Allowed(net.ParseIP(s.clientHost(r))) {
+		// Allowlisted clients bypass api keys and quotas
+		return &requestQuota{bucket: s.rateLimitKey(r)}, 0
+	}
+
+	fallback := Policy{Limit: s.Config.RateLimitLimit, Burst: s.Config.RateLimitBurst}
+
+	if key := apiKey(r); key != "" && s.APIKeys != nil {
+		k := s.APIKeys.Get(key)
+		if k == nil {
+			return nil, http.StatusUnauthorized
+		}
+		p := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(s.Config.APIPrefix, "/"))
+		if !k.allowsEndpoint(p) || !k.allowsOrigin(r.Header.Get("Origin")) {
+			return nil, http.StatusForbidden
+		}
+		return &requestQuota{bucket: k.bucket(), policy: k.policy(fallback), apiKey: k}, 0
+	}
+
+	if s.Config.APIKeyRequired {
+		return nil, http.StatusUnauthorized
+	}
+
+	rq := &requestQuota{bucket: s.rateLimitKey(r), policy: fallback}
+	if len(s.policies) > 0 {
+		if qp := s.matchPolicy(r); qp != nil {
+			if qp.action == "block" {
+				return nil, http.StatusForbidden
+			}
+			// Clients of different policies never share a bucket
+			rq.bucket = "policy:" + qp.name + ":" + rq.bucket
+			rq.policy = qp.policy(fallback)
+		}
+	}
+	return rq, 0
+}
+
+// quotaError is returned for requests rejected by the rate limit.
+type quotaError struct {
+	n     int   // Number of requested requests
+	quota Quota // Quota after the rejection
+}
+
+func (e *quotaError) Error() string {
+	if e.quota.Oversized {
+		return fmt.Sprintf("request costs %d requests, which exceeds the limit of %d", e.n, e.quota.Limit)
+	}
+	return strings.ToLower(http.StatusText(http.StatusTooManyRequests))
+}
+
+// write sends the error response. Requests exceeding the limit will never
+// be allowed and are rejected as too large instead of too many.
+func (e *quotaError) write(w http.ResponseWriter) {
+	if e.quota.Oversized {
+		http.Error(w, fmt.Sprintf("Request costs %d requests, which exceeds the limit of %d.", e.n, e.quota.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// takeQuota charges n requests to the quota of the request. If w is not
+// nil, the rate limit headers are set and rejected requests are answered
+// with the error response. An error is returned if the requests are not
+// allowed.
+func (s *Server) takeQuota(w http.ResponseWriter, r *http.Request, n int) error {
+	rq := requestQuotaFrom(r)
+	if rq == nil || rq.policy.Limit <= 0 {
+		return nil
+	}
+	q := s.RateLimit.TakeN(rq.bucket, n, rq.policy)
+	if w != nil {
+		q.setHeaders(w)
+	}
+	if q.Allowed {
+		return nil
+	}
+	err := &quotaError{n: n, quota: q}
+	if w != nil {
+		err.write(w)
+	}
+	return err
+}
+
+// waitQuota blocks until the quota of the request allows n more requests
+// or the context of the request is canceled.
+func (s *Server) waitQuota(r *http.Request, n int) error {
+	rq := requestQuotaFrom(r)
+	if rq == nil || rq.policy.Limit <= 0 || n <= 0 {
+		return nil
+	}
+	return s.RateLimit.Wait(r.Context(), rq.bucket, n, rq.policy)
+}
+
+// routeCost returns the number of requests a lookup of the route is
+// charged with. Routes cost 1 request unless configured otherwise.
+func (s *Server) routeCost(rt *route) int {
+	if cost, ok := s.Config.RateLimitCosts[rt.path]; ok {
+		return cost
+	}
+	if rt.free {
+		return 0
+	}
+	return 1
+}
+
+// validateCosts checks that every key of QUOTA_COSTS is either a route
+// pattern as registered, e.g. /json/*host, a query parameter or hostname.
+func (s *Server) validateCosts(routes []*route) error {
+	for key := range s.Config.RateLimitCosts {
+		if !strings.HasPrefix(key, "/") {
+			if _, ok := queryParams[key]; !ok && key != "hostname" {
+				return fmt.Errorf("quota costs: unknown option %s", key)
+			}
+			continue
+		}
+		found := false
+		for _, rt := range routes {
+			found = found || rt.path == key
+		}
+		if !found {
+			return fmt.Errorf("quota costs: unknown route %s", key)
+		}
+	}
+	return nil
+}
+
+// optionCost returns the additional cost of the query options of the
+// request, e.g. the user enrichment.
+func (s *Server) optionCost(r *http.Request) int {
+	cost := 0
+	query := r.URL.Query()
+	for option, c := range s.Config.RateLimitCosts {
+		if strings.HasPrefix(option, "/") || option == "hostname" {
+			continue
+		}
+		if _, ok := query[option]; ok {
+			cost += c
+		}
+	}
+	return cost
+}
+
+// hostCost returns the additional cost of a lookup which requires the
+// resolution of a hostname.
+func (s *Server) hostCost(host string) int {
+	if host == "" || net.ParseIP(host) != nil {
+		return 0
+	}
+	return s.Config.RateLimitCosts["hostname"]
+}
+
+// entryCost returns the cost of a single lookup of the given host.
+func (s *Server) entryCost(r *http.Request, host string) int {
+	rq := requestQuotaFrom(r)
+	if rq == nil {
+		return 0
+	}
+	return rq.cost + s.hostCost(host)
+}
+
+// batchCost returns the cost of all entries of a batch. The first entry
+// is already charged for the request itself, apart from the resolution
+// of its hostname.
+func (s *Server) batchCost(r *http.Request, hosts []string) int {
+	cost := 0
+	for i, host := range hosts {
+		if i == 0 {
+			cost += s.hostCost(host)
+		} else {
+			cost += s.entryCost(r, host)
+		}
+	}
+	return cost
+}
+
+// quotaHandler charges the cost of the route to the quota resolved by the
+// rate limit middleware before calling the handler of the route.
+func (s *Server) quotaHandler(rt *route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rq := requestQuotaFrom(r)
+		if rq == nil {
+			rt.handler(w, r)
+			return
+		}
+
+		rq.cost = s.routeCost(rt)
+		if !rt.free {
+			rq.cost += s.optionCost(r)
+		}
+		err := s.takeQuota(w, r, rq.cost+s.hostCost(getRequestParam(r, "host")))
+		if rq.apiKey != nil {
+			s.APIKeys.count(rq.apiKey, err != nil)
+		}
+		if err != nil {
+			return
+		}
+
+		rt.handler(w, r)
+	}
+}
+
+// Quota writes the remaining quota of the client without charging it.
+func (s *Server) Quota(w http.ResponseWriter, r *http.Request) {
+	record := &QuotaRecord{}
+	if rq := requestQuotaFrom(r); rq != nil && rq.policy.Limit > 0 {
+		q := s.RateLimit.TakeN(rq.bucket, 0, rq.policy)
+		record = &QuotaRecord{
+			Limited:   true,
+			Rate:      rq.policy.Limit,
+			Limit:     q.Limit,
+			Remaining: q.Remaining,
+			Reset:     int64(math.Ceil(float64(q.Reset.UnixNano()) / 1e9)),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(record)
+}
+
+// rateLimitMiddleware resolves the quota of the request and rejects
+// requests which are not allowed at all. The cost of the request is
+// charged by the quotaHandler of its route.
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			// CORS preflight requests never carry an api key
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rq, code := s.resolveQuota(r)
+		if code != 0 {
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestQuotaKey{}, rq)))
+	})
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../utils/config"
+)
+
+// quotaPolicy is the compiled form of a config.QuotaPolicy.
+type quotaPolicy struct {
+	name    string
+	action  string
+	limit   int
+	burst   int
+	matches map[string][]string // Dotted json path to accepted values
+}
+
+// newQuotaPolicies validates the policy table of the config.
+func newQuotaPolicies(policies []config.QuotaPolicy) ([]*quotaPolicy, error) {
+	var list []*quotaPolicy
+	for i, p := range policies {
+		qp := &quotaPolicy{
+			name:    p.Name,
+			action:  strings.ToLower(p.Action),
+			limit:   p.Limit,
+			burst:   p.Burst,
+			matches: make(map[string][]string),
+		}
+		if qp.name == "" {
+			qp.name = strconv.Itoa(i)
+		}
+		switch qp.action {
+		case "":
+			qp.action = "limit"
+		case "limit", "allow", "block":
+		default:
+			return nil, fmt.Errorf("quota policy %s: unknown action %s", qp.name, p.Action)
+		}
+
+		for path, value := range p.Match {
+			if !validFieldPath(reflect.TypeOf(ResponseRecord{}), strings.Split(path, ".")) {
+				return nil, fmt.Errorf("quota policy %s: unknown field %s", qp.name, path)
+			}
+			values, ok := value.([]interface{})
+			if !ok {
+				values = []interface{}{value}
+			}
+			for _, v := range values {
+				qp.matches[path] = append(qp.matches[path], policyValue(v))
+			}
+		}
+		list = append(list, qp)
+	}
+	return list, nil
+}
+
+// policyFields returns the fields required to evaluate all policies.
+func policyFields(policies []*quotaPolicy) Fields {
+	var fields Fields
+	for _, qp := range policies {
+		for path := range qp.matches {
+			fields = append(fields, path)
+		}
+	}
+	return fields
+}
+
+// match reports whether every path of the policy has one of the
+// accepted values. Ips are matched against networks in CIDR notation.
+func (qp *quotaPolicy) match(rr *ResponseRecord) bool {
+	for path, accepted := range qp.matches {
+		found := false
+		for _, value := range recordValues(reflect.ValueOf(rr), strings.Split(path, ".")) {
+			for _, a := range accepted {
+				if strings.EqualFold(value, a) || cidrContains(a, value) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// policy returns the limiter settings of the policy.
+func (qp *quotaPolicy) policy(fallback Policy) Policy {
+	if qp.action == "allow" {
+		return Policy{}
+	}
+	p := fallback
+	if qp.limit > 0 {
+		p.Limit = qp.limit
+	}
+	if qp.burst > 0 {
+		p.Burst = qp.burst
+	}
+	return p
+}
+
+// policyCache remembers the matched policy of every quota key, so that the
+// client ip is not looked up on every request.
+type policyCache struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	entries  map[string]*policyCacheEntry
+}
+
+type policyCacheEntry struct {
+	policy  *quotaPolicy // nil if no policy matched
+	expires time.Time
+}
+
+// newPolicyCache creates a cache keeping every entry for the given
+// interval.
+func newPolicyCache(interval time.Duration) *policyCache {
+	pc := &policyCache{interval: interval, entries: make(map[string]*policyCacheEntry)}
+	go pc.cleanup()
+	return pc
+}
+
+func (pc *policyCache) get(key string) (*quotaPolicy, bool) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	e, ok := pc.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.policy, true
+}
+
+func (pc *policyCache) set(key string, qp *quotaPolicy) {
+	pc.mutex.Lock()
+	pc.entries[key] = &policyCacheEntry{policy: qp, expires: time.Now().Add(pc.interval)}
+	pc.mutex.Unlock()
+}
+
+func (pc *policyCache) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+
+		pc.mutex.Lock()
+		for key, e := range pc.entries {
+			if time.Now().After(e.expires) {
+				delete(pc.entries, key)
+			}
+		}
+		pc.mutex.Unlock()
+	}
+}
+
+// matchPolicy returns the first policy matching the client or nil if none
+// matches. The client ip is looked up once per quota key and interval,
+// failed lookups are retried with the next request.
+func (s *Server) matchPolicy(r *http.Request) *quotaPolicy {
+	key := s.rateLimitKey(r)
+	if qp, ok := s.policyCache.get(key); ok {
+		return qp
+	}
+
+	rr, err := s.lookupHost(s.clientHost(r), s.policyFields, r)
+	if err != nil {
+		if !s.Config.Silent {
+			log.Println("quota policy:", err)
+		}
+		return nil
+	}
+	var matched *quotaPolicy
+	for _, qp := range s.policies {
+		if qp.match(rr) {
+			matched = qp
+			break
+		}
+	}
+	s.policyCache.set(key, matched)
+	return matched
+}
+
+// recordValues returns the values found at the given path formatted as
+// strings. Lists contribute one value per element.
+func recordValues(v reflect.Value, parts []string) []string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if len(parts) == 0 {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if name, ok := jsonFieldName(v.Type().Field(i)); ok && name == parts[0] {
+				return recordValues(v.Field(i), parts[1:])
+			}
+		}
+		return nil
+	case reflect.Slice:
+		var values []string
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, recordValues(v.Index(i), parts)...)
+		}
+		return values
+	}
+	return []string{policyValue(v.Interface())}
+}
+
+func policyValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+func cidrContains(cidr string, ip string) bool {
+	if !strings.Contains(cidr, "/") {
+		return false
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return err == nil && network.Contains(net.ParseIP(ip))
+}
@@ -1,12 +1,78 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"../utils/config"
 	"golang.org/x/time/rate"
 )
 
+// Limiter is implemented by all rate limit backends. Every key gets its
+// own token bucket which refills with the limit of the given policy per
+// second up to its burst.
+type Limiter interface {
+	// TakeN consumes n requests for the given key if they are allowed and
+	// returns the resulting quota.
+	TakeN(key string, n int, p Policy) Quota
+	// Wait blocks until n more requests are allowed for the given key and
+	// consumes them, or until the context is canceled.
+	Wait(ctx context.Context, key string, n int, p Policy) error
+}
+
+// Policy describes the token bucket of a key.
+type Policy struct {
+	Limit int // Requests per second the bucket refills with
+	Burst int // Max number of requests at once
+}
+
+// Quota describes the state of a rate limit after a request.
+type Quota struct {
+	Allowed    bool
+	Limit      int           // Max number of requests at once
+	Remaining  int           // Number of requests which are allowed now
+	Reset      time.Time     // Point in time the whole limit is available again
+	RetryAfter time.Duration // Time until a rejected request would be allowed
+	Oversized  bool          // More requests than the limit were requested, they are never allowed
+}
+
+// setHeaders adds the X-RateLimit headers and, for rejected requests which
+// can be retried, the Retry-After header to the response.
+func (q Quota) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(q.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(q.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(q.Reset.UnixNano())/1e9)), 10))
+	if !q.Allowed && !q.Oversized {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(math.Max(q.RetryAfter.Seconds(), 1)))))
+	}
+}
+
+// NewLimiter creates the rate limit backend selected by QUOTA_BACKEND.
+func NewLimiter(c *config.Config) (Limiter, error) {
+	switch c.RateLimitBackend {
+	case "map", "":
+		rl := NewRateLimit(c.RateLimitInterval)
+		if c.RateLimitStateFile != "" {
+			if err := rl.Load(c.RateLimitStateFile); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("invalid quota state file %s: %v", c.RateLimitStateFile, err)
+			}
+			go rl.saveEvery(c.RateLimitStateFile, c.RateLimitStateInterval)
+		}
+		return rl, nil
+	case "redis":
+		return NewRedisRateLimit(c)
+	case "memcache":
+		return NewMemcacheRateLimit(c)
+	}
+	return nil, fmt.Errorf("unknown quota backend: %s", c.RateLimitBackend)
+}
+
 // Create a custom visitor struct which holds the rate limiter for each
 // visitor and the last time that the visitor was seen.
 type Visitor struct {
@@ -15,17 +81,13 @@ type Visitor struct {
 }
 
 type RateLimit struct {
-	Limit rate.Limit
-	Burst int
 	Interval   time.Duration
 	Mutex  *sync.RWMutex
 	Visitors map[string]*Visitor
 }
 
-func NewRateLimit(limit int, burst int, interval time.Duration) *RateLimit {
+func NewRateLimit(interval time.Duration) *RateLimit {
 	conf := &RateLimit{
-		Limit: rate.Limit(limit),
-		Burst: burst,
 		Interval: interval,
 		Visitors:  make(map[string]*Visitor),
 		Mutex: &sync.RWMutex{},
@@ -37,33 +99,83 @@ func NewRateLimit(limit int, burst int, interval time.Duration) *RateLimit {
 
 // GetLimiter returns the rate limiter for the provided IP address if it exists.
 // Otherwise calls AddIP to add IP address to the map
-func (i *RateLimit) GetLimiter(ip string) *rate.Limiter {
+func (i *RateLimit) GetLimiter(ip string, p Policy) *rate.Limiter {
 	i.Mutex.Lock()
 	visitor, exists := i.Visitors[ip]
 
 	if !exists {
 		i.Mutex.Unlock()
-		return i.AddIP(ip)
+		return i.AddIP(ip, p)
 	}
 
+	visitor.lastSeen = time.Now()
 	i.Mutex.Unlock()
 
+	visitor.apply(p)
 	return visitor.limiter
 }
 
 // AddIP creates a new rate limiter and adds it to the ips map,
 // using the IP address as the key
-func (i *RateLimit) AddIP(ip string) *rate.Limiter {
+func (i *RateLimit) AddIP(ip string, p Policy) *rate.Limiter {
 	i.Mutex.Lock()
 	defer i.Mutex.Unlock()
 
-	limiter := rate.NewLimiter(i.Limit, i.Burst)
+	// Another request might have added the IP address in the meantime
+	if visitor, exists := i.Visitors[ip]; exists {
+		visitor.lastSeen = time.Now()
+		visitor.apply(p)
+		return visitor.limiter
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(p.Limit), p.Burst)
 
 	i.Visitors[ip] = &Visitor{limiter, time.Now()}
 
 	return limiter
 }
 
+// apply updates the limiter if the policy of the visitor has changed.
+func (v *Visitor) apply(p Policy) {
+	if v.limiter.Limit() != rate.Limit(p.Limit) {
+		v.limiter.SetLimit(rate.Limit(p.Limit))
+	}
+	if v.limiter.Burst() != p.Burst {
+		v.limiter.SetBurst(p.Burst)
+	}
+}
+
+// TakeN consumes n requests for the provided IP address if they are
+// allowed. Rejected requests do not consume any tokens.
+func (i *RateLimit) TakeN(ip string, n int, p Policy) Quota {
+	if n < 0 {
+		n = 0
+	}
+	limiter := i.GetLimiter(ip, p)
+	now := time.Now()
+	q := Quota{Allowed: true, Limit: p.Burst}
+
+	if r := limiter.ReserveN(now, n); !r.OK() {
+		q.Allowed = false
+		q.Oversized = true
+	} else if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		q.Allowed = false
+		q.RetryAfter = delay
+	}
+
+	tokens := limiter.TokensAt(now)
+	q.Remaining = int(math.Max(0, math.Floor(tokens)))
+	q.Reset = now.Add(time.Duration((float64(p.Burst) - tokens) / float64(p.Limit) * float64(time.Second)))
+	return q
+}
+
+// Wait blocks until n more requests are allowed for the provided IP
+// address or the context is canceled.
+func (i *RateLimit) Wait(ctx context.Context, ip string, n int, p Policy) error {
+	return i.GetLimiter(ip, p).WaitN(ctx, n)
+}
+
 func (i *RateLimit) cleanupVisitors() {
 	for {
 		time.Sleep(time.Minute)
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"../utils/config"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// memcacheKeyPrefix is prepended to every rate limit key stored in
+// memcache.
+const memcacheKeyPrefix = "gogeoip:quota:"
+
+// MemcacheRateLimit counts requests per key within fixed windows of the
+// quota interval. Every window allows the same average rate as the token
+// bucket backends, but at least the configured burst. The counters are
+// shared between all instances using the same memcache servers.
+type MemcacheRateLimit struct {
+	Interval time.Duration
+	client   *memcache.Client
+}
+
+// NewMemcacheRateLimit connects to all servers listed in MemcacheAddr. An
+// error is returned if any of them can not be reached.
+func NewMemcacheRateLimit(c *config.Config) (*MemcacheRateLimit, error) {
+	var servers []string
+	for _, addr := range strings.Split(c.MemcacheAddr, ",") {
+		servers = append(servers, strings.TrimSpace(addr))
+	}
+	client := memcache.New(servers...)
+	client.Timeout = c.MemcacheTimeout
+	if err := client.Ping(); err != nil {
+		return nil, fmt.Errorf("memcache quota backend unreachable: %v", err)
+	}
+	return NewMemcacheRateLimitWithClient(client, c.RateLimitInterval), nil
+}
+
+// NewMemcacheRateLimitWithClient creates a limiter using the given client.
+func NewMemcacheRateLimitWithClient(client *memcache.Client, interval time.Duration) *MemcacheRateLimit {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return &MemcacheRateLimit{
+		Interval: interval,
+		client:   client,
+	}
+}
+
+// Allowance returns the number of requests the policy allows per window.
+func (rl *MemcacheRateLimit) Allowance(p Policy) uint64 {
+	allowance := uint64(p.Limit) * uint64(rl.Interval/time.Second)
+	if allowance < uint64(p.Burst) {
+		allowance = uint64(p.Burst)
+	}
+	return allowance
+}
+
+// TakeN consumes n requests for the given key if they are allowed.
+// Requests are allowed if memcache is unavailable.
+func (rl *MemcacheRateLimit) TakeN(key string, n int, p Policy) Quota {
+	if n < 0 {
+		n = 0
+	}
+	q, err := rl.take(key, n, p)
+	if err != nil {
+		log.Println("memcache quota backend:", err)
+		return Quota{Allowed: true, Limit: int(rl.Allowance(p)), Remaining: int(rl.Allowance(p)), Reset: q.Reset}
+	}
+	return q
+}
+
+// Wait blocks until n more requests are allowed for the given key or the
+// context is canceled.
+func (rl *MemcacheRateLimit) Wait(ctx context.Context, key string, n int, p Policy) error {
+	if uint64(n) > rl.Allowance(p) {
+		return fmt.Errorf("wait(n=%d) exceeds allowance %d", n, rl.Allowance(p))
+	}
+	for {
+		q, err := rl.take(key, n, p)
+		if err != nil {
+			log.Println("memcache quota backend:", err)
+			return nil
+		}
+		if q.Allowed {
+			return nil
+		}
+
+		t := time.NewTimer(q.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// take counts n requests within the current window and returns the
+// resulting quota.
+func (rl *MemcacheRateLimit) take(key string, n int, p Policy) (Quota, error) {
+	allowance := rl.Allowance(p)
+	now := time.Now()
+	window := now.Truncate(rl.Interval)
+	q := Quota{Limit: int(allowance), Reset: window.Add(rl.Interval)}
+	if uint64(n) > allowance {
+		q.Oversized = true
+		return q, nil
+	}
+	key = memcacheKeyPrefix + key + ":" + strconv.FormatInt(window.Unix(), 10)
+
+	count, err := rl.client.Increment(key, uint64(n))
+	if err == memcache.ErrCacheMiss {
+		err = rl.client.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte(strconv.Itoa(n)),
+			Expiration: int32(rl.Interval/time.Second) + 1,
+		})
+		if err == nil {
+			count = uint64(n)
+		} else if err == memcache.ErrNotStored {
+			// Another request created the counter in the meantime
+			count, err = rl.client.Increment(key, uint64(n))
+		}
+	}
+	if err != nil {
+		return q, err
+	}
+
+	if count > allowance {
+		// Rejected requests do not count against the quota
+		if count, err = rl.client.Decrement(key, uint64(n)); err != nil {
+			count = allowance
+		}
+		q.RetryAfter = q.Reset.Sub(now)
+	} else {
+		q.Allowed = true
+	}
+	if count < allowance {
+		q.Remaining = int(allowance - count)
+	}
+	return q, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"../utils/config"
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisKeyPrefix is prepended to every rate limit key stored in redis.
+const redisKeyPrefix = "gogeoip:quota:"
+
+// tokenBucketScript atomically refills and consumes the token bucket stored
+// in KEYS[1]. It returns whether the request is allowed, the remaining
+// tokens and the number of milliseconds until enough tokens are available.
+var tokenBucketScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local wait = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	wait = math.ceil((n - tokens) * 1000 / rate)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, tostring(tokens), wait}
+`)
+
+// RedisRateLimit is a token bucket rate limiter stored in redis. It allows
+// several instances to share the same quota. Keys are distributed over all
+// configured redis servers.
+type RedisRateLimit struct {
+	Interval time.Duration
+	pools    []*redis.Pool
+}
+
+// NewRedisRateLimit connects to all servers listed in RedisAddr. An error
+// is returned if any of them can not be reached.
+func NewRedisRateLimit(c *config.Config) (*RedisRateLimit, error) {
+	rl := &RedisRateLimit{
+		Interval: c.RateLimitInterval,
+	}
+	for _, addr := range strings.Split(c.RedisAddr, ",") {
+		rl.pools = append(rl.pools, newRedisPool(strings.TrimSpace(addr), c.RedisTimeout))
+	}
+	for _, pool := range rl.pools {
+		conn := pool.Get()
+		_, err := conn.Do("PING")
+		_ = conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("redis quota backend unreachable: %v", err)
+		}
+	}
+	return rl, nil
+}
+
+// NewRedisRateLimitWithPool creates a limiter using the given connection
+// pool, which allows to use an in-process redis server.
+func NewRedisRateLimitWithPool(pool *redis.Pool, interval time.Duration) *RedisRateLimit {
+	return &RedisRateLimit{
+		Interval: interval,
+		pools:    []*redis.Pool{pool},
+	}
+}
+
+func newRedisPool(addr string, timeout time.Duration) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     16,
+		IdleTimeout: time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr,
+				redis.DialConnectTimeout(timeout),
+				redis.DialReadTimeout(timeout),
+				redis.DialWriteTimeout(timeout),
+			)
+		},
+	}
+}
+
+// TakeN consumes n requests for the given key if they are allowed.
+// Requests are allowed if redis is unavailable.
+func (rl *RedisRateLimit) TakeN(key string, n int, p Policy) Quota {
+	if n < 0 {
+		n = 0
+	}
+	q, err := rl.take(key, n, p)
+	if err != nil {
+		log.Println("redis quota backend:", err)
+		return Quota{Allowed: true, Limit: p.Burst, Remaining: p.Burst, Reset: time.Now()}
+	}
+	return q
+}
+
+// Wait blocks until n more requests are allowed for the given key or the
+// context is canceled.
+func (rl *RedisRateLimit) Wait(ctx context.Context, key string, n int, p Policy) error {
+	if n > p.Burst {
+		return fmt.Errorf("wait(n=%d) exceeds burst %d", n, p.Burst)
+	}
+	for {
+		q, err := rl.take(key, n, p)
+		if err != nil {
+			log.Println("redis quota backend:", err)
+			return nil
+		}
+		if q.Allowed {
+			return nil
+		}
+
+		t := time.NewTimer(q.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// take runs the token bucket script and returns the resulting quota.
+func (rl *RedisRateLimit) take(key string, n int, p Policy) (Quota, error) {
+	now := time.Now()
+	q := Quota{Limit: p.Burst, Reset: now}
+	if n > p.Burst {
+		q.Oversized = true
+		return q, nil
+	}
+
+	conn := rl.pool(key).Get()
+	defer conn.Close()
+
+	ttl := rl.Interval
+	// Keep the bucket at least until it would be refilled completely
+	if refill := time.Duration(p.Burst) * time.Second / time.Duration(p.Limit); refill > ttl {
+		ttl = refill
+	}
+	values, err := redis.Values(tokenBucketScript.Do(conn, redisKeyPrefix+key,
+		p.Limit, p.Burst, now.UnixNano()/int64(time.Millisecond), n, ttl.Milliseconds()))
+	if err != nil {
+		return q, err
+	}
+
+	var allowed, wait int64
+	var tokens float64
+	if _, err := redis.Scan(values, &allowed, &tokens, &wait); err != nil {
+		return q, err
+	}
+	q.Allowed = allowed == 1
+	q.Remaining = int(math.Max(0, math.Floor(tokens)))
+	q.Reset = now.Add(time.Duration((float64(p.Burst) - tokens) / float64(p.Limit) * float64(time.Second)))
+	q.RetryAfter = time.Duration(wait) * time.Millisecond
+	return q, nil
+}
+
+func (rl *RedisRateLimit) pool(key string) *redis.Pool {
+	if len(rl.pools) == 1 {
+		return rl.pools[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return rl.pools[h.Sum32()%uint32(len(rl.pools))]
+}
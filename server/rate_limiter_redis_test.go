@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func newTestRedisRateLimit(t *testing.T, interval time.Duration) (*RedisRateLimit, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", m.Addr())
+		},
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	return NewRedisRateLimitWithPool(pool, interval), m
+}
+
+func TestRedisRateLimitTakeN(t *testing.T) {
+	rl, _ := newTestRedisRateLimit(t, time.Minute)
+	p := Policy{Limit: 1, Burst: 3}
+
+	q := rl.TakeN("client", 2, p)
+	if !q.Allowed || q.Limit != 3 || q.Remaining != 1 {
+		t.Fatalf("first take: got %+v", q)
+	}
+	q = rl.TakeN("client", 2, p)
+	if q.Allowed {
+		t.Fatalf("second take exceeding the remaining tokens: got %+v", q)
+	}
+	if q.RetryAfter <= 0 || q.RetryAfter > time.Second {
+		t.Errorf("retry after: got %v, want up to 1s", q.RetryAfter)
+	}
+	if q.Remaining != 1 {
+		t.Errorf("rejected requests must not consume tokens: got %d remaining", q.Remaining)
+	}
+	if q := rl.TakeN("other", 3, p); !q.Allowed {
+		t.Errorf("keys must not share a bucket: got %+v", q)
+	}
+}
+
+func TestRedisRateLimitRefill(t *testing.T) {
+	rl, _ := newTestRedisRateLimit(t, time.Minute)
+	p := Policy{Limit: 20, Burst: 1}
+
+	if q := rl.TakeN("client", 1, p); !q.Allowed {
+		t.Fatalf("first take: got %+v", q)
+	}
+	q := rl.TakeN("client", 1, p)
+	if q.Allowed {
+		t.Fatalf("empty bucket: got %+v", q)
+	}
+	time.Sleep(q.RetryAfter + 10*time.Millisecond)
+	if q := rl.TakeN("client", 1, p); !q.Allowed {
+		t.Fatalf("refilled bucket: got %+v", q)
+	}
+}
+
+func TestRedisRateLimitExceedsBurst(t *testing.T) {
+	rl, m := newTestRedisRateLimit(t, time.Minute)
+	p := Policy{Limit: 1, Burst: 2}
+
+	if q := rl.TakeN("client", 3, p); q.Allowed || !q.Oversized || q.RetryAfter != 0 {
+		t.Errorf("take more than burst: got %+v", q)
+	}
+	if err := rl.Wait(context.Background(), "client", 3, p); err == nil {
+		t.Error("wait for more than burst: got no error")
+	}
+	if m.Exists(redisKeyPrefix + "client") {
+		t.Error("requests exceeding the burst must not touch the bucket")
+	}
+}
+
+func TestRedisRateLimitWait(t *testing.T) {
+	rl, _ := newTestRedisRateLimit(t, time.Minute)
+	p := Policy{Limit: 20, Burst: 2}
+
+	if q := rl.TakeN("client", 2, p); !q.Allowed {
+		t.Fatalf("first take: got %+v", q)
+	}
+	start := time.Now()
+	if err := rl.Wait(context.Background(), "client", 1, p); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("wait returned after %v, want at least 50ms", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rl.Wait(ctx, "client", 2, p); err != context.Canceled {
+		t.Errorf("wait with canceled context: got %v", err)
+	}
+}
+
+func TestRedisRateLimitExpiry(t *testing.T) {
+	rl, m := newTestRedisRateLimit(t, time.Second)
+
+	// The bucket is kept until it would be refilled completely
+	rl.TakeN("slow", 5, Policy{Limit: 1, Burst: 10})
+	if ttl := m.TTL(redisKeyPrefix + "slow"); ttl != 10*time.Second {
+		t.Errorf("ttl of a slowly refilling bucket: got %v, want 10s", ttl)
+	}
+	// but at least for the configured interval
+	rl.TakeN("fast", 5, Policy{Limit: 100, Burst: 10})
+	if ttl := m.TTL(redisKeyPrefix + "fast"); ttl != time.Second {
+		t.Errorf("ttl of a fast refilling bucket: got %v, want 1s", ttl)
+	}
+
+	m.FastForward(10 * time.Second)
+	if m.Exists(redisKeyPrefix + "slow") {
+		t.Error("bucket has not expired")
+	}
+	if q := rl.TakeN("slow", 10, Policy{Limit: 1, Burst: 10}); !q.Allowed {
+		t.Errorf("expired bucket must start full: got %+v", q)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitState is the snapshot of the map backend stored on disk.
+type rateLimitState struct {
+	Saved    time.Time                `json:"saved"`
+	Visitors map[string]*visitorState `json:"visitors"`
+}
+
+type visitorState struct {
+	Tokens   float64   `json:"tokens"`
+	Limit    float64   `json:"limit"`
+	Burst    int       `json:"burst"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Save writes the tokens and last seen time of every visitor to the file.
+// The file is replaced atomically.
+func (i *RateLimit) Save(file string) error {
+	now := time.Now()
+	state := &rateLimitState{Saved: now, Visitors: make(map[string]*visitorState)}
+
+	i.Mutex.RLock()
+	for key, v := range i.Visitors {
+		state.Visitors[key] = &visitorState{
+			Tokens:   v.limiter.TokensAt(now),
+			Limit:    float64(v.limiter.Limit()),
+			Burst:    v.limiter.Burst(),
+			LastSeen: v.lastSeen,
+		}
+	}
+	i.Mutex.RUnlock()
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Load restores the visitors of a snapshot. Visitors which have not been
+// seen within the quota interval are dropped. The tokens of the remaining
+// visitors are refilled for the time since the snapshot was taken.
+func (i *RateLimit) Load(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	state := &rateLimitState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	i.Mutex.Lock()
+	defer i.Mutex.Unlock()
+	for key, v := range state.Visitors {
+		if now.Sub(v.LastSeen) > i.Interval {
+			continue
+		}
+		limiter := rate.NewLimiter(rate.Limit(v.Limit), v.Burst)
+		// A new limiter is full, take the tokens which were missing
+		if missing := int(math.Ceil(float64(v.Burst) - v.Tokens)); missing > 0 {
+			limiter.ReserveN(state.Saved, int(math.Min(float64(missing), float64(v.Burst))))
+		}
+		i.Visitors[key] = &Visitor{limiter, v.LastSeen}
+	}
+	return nil
+}
+
+// saveEvery writes a snapshot to the file at the given interval.
+func (i *RateLimit) saveEvery(file string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		if err := i.Save(file); err != nil {
+			log.Println("quota state:", err)
+		}
+	}
+}
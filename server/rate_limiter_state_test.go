@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestState(t *testing.T, state *rateLimitState) string {
+	content, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "quota.json")
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestRateLimitSaveLoad(t *testing.T) {
+	p := Policy{Limit: 1, Burst: 5}
+	rl := NewRateLimit(time.Minute)
+	rl.TakeN("client", 4, p)
+
+	file := filepath.Join(t.TempDir(), "quota.json")
+	if err := rl.Save(file); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restored := NewRateLimit(time.Minute)
+	if err := restored.Load(file); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	v, ok := restored.Visitors["client"]
+	if !ok {
+		t.Fatal("visitor has not been restored")
+	}
+	if v.limiter.Limit() != 1 || v.limiter.Burst() != 5 {
+		t.Errorf("policy: got limit %v and burst %d", v.limiter.Limit(), v.limiter.Burst())
+	}
+	if q := restored.TakeN("client", 2, p); q.Allowed {
+		t.Errorf("restored client got a fresh quota: %+v", q)
+	}
+	if q := restored.TakeN("client", 1, p); !q.Allowed {
+		t.Errorf("remaining token has been lost: %+v", q)
+	}
+}
+
+func TestRateLimitLoadRefill(t *testing.T) {
+	now := time.Now()
+	file := writeTestState(t, &rateLimitState{
+		Saved: now.Add(-3 * time.Second),
+		Visitors: map[string]*visitorState{
+			"client": {Tokens: 0, Limit: 1, Burst: 5, LastSeen: now.Add(-3 * time.Second)},
+		},
+	})
+
+	rl := NewRateLimit(time.Minute)
+	if err := rl.Load(file); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// The bucket refills for the time since the snapshot was taken
+	tokens := rl.Visitors["client"].limiter.TokensAt(now)
+	if math.Abs(tokens-3) > 0.01 {
+		t.Errorf("tokens: got %v, want 3", tokens)
+	}
+}
+
+func TestRateLimitLoadDropsStale(t *testing.T) {
+	now := time.Now()
+	file := writeTestState(t, &rateLimitState{
+		Saved: now,
+		Visitors: map[string]*visitorState{
+			"active": {Tokens: 1, Limit: 1, Burst: 5, LastSeen: now.Add(-30 * time.Second)},
+			"stale":  {Tokens: 1, Limit: 1, Burst: 5, LastSeen: now.Add(-2 * time.Minute)},
+		},
+	})
+
+	rl := NewRateLimit(time.Minute)
+	if err := rl.Load(file); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := rl.Visitors["active"]; !ok {
+		t.Error("active visitor has been dropped")
+	}
+	if _, ok := rl.Visitors["stale"]; ok {
+		t.Error("stale visitor has been restored")
+	}
+	if !rl.Visitors["active"].lastSeen.Equal(now.Add(-30 * time.Second)) {
+		t.Errorf("last seen: got %v", rl.Visitors["active"].lastSeen)
+	}
+}
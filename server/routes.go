@@ -0,0 +1,266 @@
+package server
+
+import (
+	"net/http"
+)
+
+// route describes a single api endpoint. The route table is used to
+// register all handlers as well as to generate the OpenAPI document.
+type route struct {
+	method   string
+	path     string
+	summary  string
+	params   []string         // Supported query parameters, see queryParams
+	request  *mediaType       // Optional request body
+	response []*mediaType     // Possible response bodies
+	handler  http.HandlerFunc // Routes without handler are only documented
+	free     bool             // Requests are not charged to the quota by default
+	admin    bool             // Requests require the admin token
+}
+
+// mediaType describes a request or response body. The schema is derived
+// from the type of the given value.
+type mediaType struct {
+	contentType string
+	value       interface{}
+}
+
+var lookupParams = []string{"lang", "user", "fields"}
+
+// negotiatedMediaTypes returns the canonical media type of every output
+// format.
+func negotiatedMediaTypes() []*mediaType {
+	var mediaTypes []*mediaType
+	for _, f := range outputFormats {
+		var value interface{} = &ResponseRecord{}
+		if f.name == "csv" {
+			value = ""
+		}
+		mediaTypes = append(mediaTypes, &mediaType{f.mediaTypes[0], value})
+	}
+	return mediaTypes
+}
+
+func (s *Server) routes() []*route {
+	return []*route{
+		{
+			method:   http.MethodGet,
+			path:     "/csv/*host",
+			summary:  "Lookup an ip or hostname and return the result as csv",
+			params:   append(lookupParams, "header"),
+			response: []*mediaType{{"text/csv", ""}},
+			handler:  s.Api.cors.Handler(s.CsvLookUp()).ServeHTTP,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/csv/schema",
+			summary:  "List the name and type of every csv column",
+			params:   []string{"user", "fields"},
+			response: []*mediaType{{"text/csv", ""}},
+			handler:  nil, // Served by the /csv/*host route
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/xml/*host",
+			summary:  "Lookup an ip or hostname and return the result as xml",
+			params:   lookupParams,
+			response: []*mediaType{{"application/xml", &ResponseRecord{}}},
+			handler:  s.registerHandler(xmlResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/json/*host",
+			summary:  "Lookup an ip or hostname and return the result as json or jsonp",
+			params:   append(lookupParams, "callback"),
+			response: []*mediaType{{"application/json", &ResponseRecord{}}, {"application/javascript", ""}},
+			handler:  s.registerHandler(jsonResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/geojson/*host",
+			summary:  "Lookup an ip or hostname and return the result as GeoJSON Feature",
+			params:   append(lookupParams, "accuracy"),
+			response: []*mediaType{{"application/geo+json", &GeoJSONFeature{}}},
+			handler:  s.registerHandler(geoJSONResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/msgpack/*host",
+			summary:  "Lookup an ip or hostname and return the result as MessagePack",
+			params:   lookupParams,
+			response: []*mediaType{{"application/msgpack", &ResponseRecord{}}},
+			handler:  s.registerHandler(msgpackResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/cbor/*host",
+			summary:  "Lookup an ip or hostname and return the result as CBOR",
+			params:   lookupParams,
+			response: []*mediaType{{"application/cbor", &ResponseRecord{}}},
+			handler:  s.registerHandler(cborResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/proto/*host",
+			summary:  "Lookup an ip or hostname and return the result as protobuf ResponseRecord message",
+			params:   lookupParams,
+			response: []*mediaType{{"application/x-protobuf", &ResponseRecord{}}},
+			handler:  s.registerHandler(protoResponse),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/lookup/*host",
+			summary:  "Lookup an ip or hostname and return the result in the format selected by the Accept header",
+			params:   append(lookupParams, "format", "callback", "header"),
+			response: negotiatedMediaTypes(),
+			handler:  s.Api.cors.Handler(s.NegotiatedLookUp()).ServeHTTP,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/csv/batch",
+			summary:  "Lookup a list of ips or hostnames and return the results as csv",
+			params:   append(lookupParams, "header"),
+			request:  &mediaType{"application/json", []string{}},
+			response: []*mediaType{{"text/csv", ""}},
+			handler:  s.registerBatchHandler(csvBatchResponse),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/xml/batch",
+			summary:  "Lookup a list of ips or hostnames and return the results as xml",
+			params:   lookupParams,
+			request:  &mediaType{"application/json", []string{}},
+			response: []*mediaType{{"application/xml", &BatchResponse{}}},
+			handler:  s.registerBatchHandler(xmlBatchResponse),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/json/batch",
+			summary:  "Lookup a list of ips or hostnames and return the results as json",
+			params:   lookupParams,
+			request:  &mediaType{"application/json", []string{}},
+			response: []*mediaType{{"application/json", []*BatchRecord{}}},
+			handler:  s.registerBatchHandler(jsonBatchResponse),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/json/bulk",
+			summary:  "Stream newline delimited ips or hostnames and receive newline delimited json records",
+			params:   lookupParams,
+			request:  &mediaType{"text/plain", ""},
+			response: []*mediaType{{"application/x-ndjson", &ResponseRecord{}}},
+			handler:  s.registerBulkHandler(ndjsonBulkFormat),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/graphql",
+			summary:  "Execute a GraphQL query provided as query parameters",
+			params:   []string{"query", "variables", "operationName"},
+			response: []*mediaType{{"application/json", map[string]interface{}{}}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.GraphQL)).ServeHTTP,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/graphql",
+			summary:  "Execute a GraphQL query provided as json request body",
+			request:  &mediaType{"application/json", &graphQLRequest{}},
+			response: []*mediaType{{"application/json", map[string]interface{}{}}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.GraphQL)).ServeHTTP,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/geojson/batch",
+			summary:  "Lookup a list of ips or hostnames and return the results as GeoJSON FeatureCollection",
+			params:   append(lookupParams, "accuracy"),
+			request:  &mediaType{"application/json", []string{}},
+			response: []*mediaType{{"application/geo+json", &GeoJSONFeatureCollection{}}},
+			handler:  s.registerBatchHandler(geoJSONBatchResponse),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/geojson/bulk",
+			summary:  "Stream newline delimited ips or hostnames and receive a streamed GeoJSON FeatureCollection",
+			params:   append(lookupParams, "accuracy"),
+			request:  &mediaType{"text/plain", ""},
+			response: []*mediaType{{"application/geo+json", &GeoJSONFeatureCollection{}}},
+			handler:  s.registerBulkHandler(geoJSONBulkFormat),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/quota",
+			summary:  "Remaining quota of the client, requests are not charged",
+			response: []*mediaType{{"application/json", &QuotaRecord{}}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.Quota)).ServeHTTP,
+			free:     true,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/usage",
+			summary:  "Usage counters of the api key sent with the request",
+			response: []*mediaType{{"application/json", &APIKeyUsage{}}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.Usage)).ServeHTTP,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/admin/access",
+			summary:  "List the denied and allowed networks",
+			response: []*mediaType{{"application/json", &AccessListRecord{}}},
+			handler:  s.AccessListAdmin,
+			free:     true,
+			admin:    true,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/admin/access",
+			summary:  "Add networks to the denylist or allowlist",
+			request:  &mediaType{"application/json", &AccessListRecord{}},
+			response: []*mediaType{{"application/json", &AccessListRecord{}}},
+			handler:  s.AccessListAdmin,
+			free:     true,
+			admin:    true,
+		},
+		{
+			method:   http.MethodDelete,
+			path:     "/admin/access",
+			summary:  "Remove networks from the denylist or allowlist",
+			request:  &mediaType{"application/json", &AccessListRecord{}},
+			response: []*mediaType{{"application/json", &AccessListRecord{}}},
+			handler:  s.AccessListAdmin,
+			free:     true,
+			admin:    true,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/admin/databases",
+			summary:  "State of all databases",
+			response: []*mediaType{{"application/json", []*DatabaseRecord{}}},
+			handler:  s.Databases,
+			free:     true,
+			admin:    true,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/admin/rollback",
+			summary:  "Restore the previous version of a downloaded database",
+			params:   []string{"database"},
+			response: []*mediaType{{"application/json", &DatabaseRecord{}}},
+			handler:  s.RollbackDatabase,
+			free:     true,
+			admin:    true,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/openapi.json",
+			summary:  "OpenAPI description of this api",
+			response: []*mediaType{{"application/json", map[string]interface{}{}}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.OpenAPI)).ServeHTTP,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/geoip.proto",
+			summary:  "Protobuf schema of all messages and the gRPC service",
+			response: []*mediaType{{"text/plain", ""}},
+			handler:  s.Api.cors.Handler(http.HandlerFunc(s.protoSchemaResponse)).ServeHTTP,
+		},
+	}
+}
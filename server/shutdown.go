@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is the time running requests are given to finish when
+// the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// serveHTTP serves the listener until the server is shut down.
+func (s *Server) serveHTTP(srv *http.Server, ln net.Listener) {
+	s.Mutex.Lock()
+	s.httpServers = append(s.httpServers, srv)
+	s.Mutex.Unlock()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// waitForShutdown blocks until the process is interrupted or terminated,
+// shuts the server down and exits. The exit status is only non-zero if the
+// shutdown failed.
+func (s *Server) waitForShutdown() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	received := <-sig
+	signal.Stop(sig)
+
+	if !s.Config.Silent {
+		log.Println("geoip server shutting down:", received)
+	}
+	if err := s.shutdown(); err != nil {
+		log.Println("shutdown:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// shutdown stops accepting requests, waits up to shutdownTimeout for the
+// running ones and saves the state of the map quota backend afterwards. An
+// error is returned if the requests did not finish in time or the state
+// could not be saved.
+func (s *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	s.Mutex.Lock()
+	servers, grpcServer := s.httpServers, s.grpcServer
+	s.Mutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var failed error
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				failed = err
+			}
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		done <- failed
+	}()
+	var failed error
+	select {
+	case failed = <-done:
+	case <-ctx.Done():
+		failed = ctx.Err()
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+	}
+
+	if rl, ok := s.RateLimit.(*RateLimit); ok && s.Config.RateLimitStateFile != "" {
+		if err := rl.Save(s.Config.RateLimitStateFile); err != nil {
+			return fmt.Errorf("quota state: %v", err)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+)
+
+// watchFile calls reload whenever the given file is created or modified.
+// The directory of the file is watched, so the file may be replaced by
+// moving another file over it. Nothing is logged if silent is set.
+func watchFile(file string, reload func() error, silent bool) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Watch(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Event:
+				if !ok {
+					return
+				}
+				if name, _ := filepath.Abs(ev.Name); name == file && (ev.IsCreate() || ev.IsModify()) {
+					time.Sleep(time.Second) // Wait until the file is written completely.
+					err := reload()
+					if silent {
+						continue
+					}
+					if err != nil {
+						log.Println("reload failed:", err)
+					} else {
+						log.Println("reloaded:", file)
+					}
+				}
+			case err, ok := <-watcher.Error:
+				if !ok {
+					return
+				}
+				if !silent {
+					log.Println("watch error:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -81,12 +81,21 @@ func RunSetup(c *config.Config){
 		c.ServerAddr = hostAddr + ":" + hostPort
 	}
 
+	c.GRPCServerAddr = s.askForInput("gRPC address in form of ip:port", "leave empty to disable", "")
+	if c.GRPCServerAddr != "" {
+		c.GRPCTLS = Stob(s.askForInput("Enable TLS for the gRPC server", "y/N", "n"))
+	}
+
 	c.FastOpen = Stob(s.askForInput("Enable TCP fast open", "y/N", "n"))
 	c.Naggle = Stob(s.askForInput("Enable TCP Nagle's algorithm (disables NO_DELAY)", "y/N", "n"))
 
 	c.APIPrefix = s.askForInput("API endpoint prefix", "Default: " + c.APIPrefix, c.APIPrefix)
 	c.CORSOrigin = s.askForInput("Comma separated list of CORS origins endpoints", "Default: " + c.CORSOrigin, c.CORSOrigin)
 	c.UseXForwardedFor = Stob(s.askForInput("Use the X-Forwarded-For header when available (e.g. behind proxy)", "y/N", "n"))
+	if c.UseXForwardedFor {
+		hops := strconv.Itoa(c.XForwardedForHops)
+		c.XForwardedForHops, _ = strconv.Atoi(s.askForInput("Number of trusted proxies appending to the X-Forwarded-For header", "Default: " + hops, hops))
+	}
 	c.GuiDir = s.askForInput("Web gui directory", "leave empty to disable", "")
 
 	availableRateLimitBackends := []string{"map", "redis", "memcache"}
@@ -123,8 +132,33 @@ func RunSetup(c *config.Config){
 	c.RateLimitLimit, _ = strconv.Atoi(s.askForInput("Max requests per source IP per interval; set 0 to turn quotas off", "Default: " + rateLimitLimit, rateLimitLimit))
 	c.RateLimitInterval = Stomd(s.askForInput("Quota expiration interval in minutes, per source IP querying the API", "Default: " + rateLimitInterval, rateLimitInterval))
 
+	rateLimitIPv4Prefix := strconv.Itoa(c.RateLimitIPv4Prefix)
+	rateLimitIPv6Prefix := strconv.Itoa(c.RateLimitIPv6Prefix)
+	c.RateLimitIPv4Prefix, _ = strconv.Atoi(s.askForInput("IPv4 prefix length of the subnet sharing one quota", "Default: " + rateLimitIPv4Prefix, rateLimitIPv4Prefix))
+	c.RateLimitIPv6Prefix, _ = strconv.Atoi(s.askForInput("IPv6 prefix length of the subnet sharing one quota", "Default: " + rateLimitIPv6Prefix, rateLimitIPv6Prefix))
+	if c.RateLimitBackend == "map" || c.RateLimitBackend == "" {
+		c.RateLimitStateFile = s.askForInput("File the map quota backend is saved to and restored from", "Default: " + c.RateLimitStateFile, c.RateLimitStateFile)
+	}
+
+	c.APIKeysFile = s.askForInput("JSON file with api keys and their quotas", "Default: " + c.APIKeysFile, c.APIKeysFile)
+	if c.APIKeysFile != "" {
+		c.APIKeyRequired = Stob(s.askForInput("Reject requests without api key", "y/N", "n"))
+	}
+
+	c.AccessListFile = s.askForInput("JSON file with denied and allowed networks", "Default: " + c.AccessListFile, c.AccessListFile)
+	c.AdminToken = s.askForInput("Bearer token required by the admin endpoints (empty to disable them)", "Default: " + c.AdminToken, c.AdminToken)
+
+	batchLimit := strconv.Itoa(c.BatchLimit)
+	c.BatchLimit, _ = strconv.Atoi(s.askForInput("Max number of ips or hosts per batch request", "Default: " + batchLimit, batchLimit))
+
 	mmRetryInterval := strconv.Itoa(int(c.MMRetryInterval.Hours()))
 	mmUpdateInterval := strconv.Itoa(int(c.MMUpdateInterval.Hours()))
+	c.MMMode = s.askForInput("MaxMind database source (url, file or disabled)", "Default: " + c.MMMode, c.MMMode)
+	if c.MMMode == "file" {
+		c.MMFile = s.askForInput("Local MaxMind database file", "Default: " + c.MMFile, c.MMFile)
+		c.MMASNFile = s.askForInput("Local MaxMind ASN database file", "Default: " + c.MMASNFile, c.MMASNFile)
+	}
+	c.MMConfigFile = s.askForInput("MaxMind GeoIP.conf file with credentials and editions", "Default: " + c.MMConfigFile, c.MMConfigFile)
 	c.MMLicenseKey = s.askForInput("MaxMind License Key", "Default: " + c.MMLicenseKey, c.MMLicenseKey)
 	c.MMUserID = s.askForInput("MaxMind User ID", "Default: " + c.MMUserID, c.MMUserID)
 	c.MMProductID = s.askForInput("MaxMind Product ID (e.g GeoLite2-City)", "Default: " + c.MMProductID, c.MMProductID)
@@ -134,6 +168,11 @@ func RunSetup(c *config.Config){
 
 	ip2RetryInterval := strconv.Itoa(int(c.MMRetryInterval.Hours()))
 	ip2UpdateInterval := strconv.Itoa(int(c.MMUpdateInterval.Hours()))
+	c.I2LMode = s.askForInput("ip2location database source (url, file or disabled)", "Default: " + c.I2LMode, c.I2LMode)
+	if c.I2LMode == "file" {
+		c.I2LFile = s.askForInput("Local ip2location BIN file", "Default: " + c.I2LFile, c.I2LFile)
+	}
+	c.I2LChecksum = s.askForInput("Url or file with the digest of the ip2location download", "Default: " + c.I2LChecksum, c.I2LChecksum)
 	c.I2LToken = s.askForInput("ip2location token", "Default: " + c.I2LToken, c.I2LToken)
 	c.I2LProductID = s.askForInput("ip2location Product ID", "Default: " + c.I2LProductID, c.I2LProductID)
 	c.I2LUpdatesHost = s.askForInput("ip2location Updates Host", "Default: " + c.I2LUpdatesHost, c.I2LUpdatesHost)
@@ -142,11 +181,17 @@ func RunSetup(c *config.Config){
 
 	torRetryInterval := strconv.Itoa(int(c.MMRetryInterval.Hours()))
 	torUpdateInterval := strconv.Itoa(int(c.MMUpdateInterval.Hours()))
+	c.TorMode = s.askForInput("Tor database source (url, file or disabled)", "Default: " + c.TorMode, c.TorMode)
+	if c.TorMode == "file" {
+		c.TorFile = s.askForInput("Local tor exit list", "Default: " + c.TorFile, c.TorFile)
+	}
 	c.TorExitCheck = s.askForInput("Tor exit check", "Default: " + c.TorExitCheck, c.TorExitCheck)
 	c.TorUpdatesHost = s.askForInput("Tor Updates Host", "Default: " + c.TorUpdatesHost, c.TorUpdatesHost)
 	c.TorRetryInterval = Stohd(s.askForInput("Max time to wait before retrying to download the tor database", "Default: " + torRetryInterval, torRetryInterval))
 	c.TorUpdateInterval = Stomd(s.askForInput("ip2location database update check interval", "Default: " + torUpdateInterval, torUpdateInterval))
 
+	c.OverrideFile = s.askForInput("CSV or YAML file with networks overriding the databases", "Default: " + c.OverrideFile, c.OverrideFile)
+
 	writeTimeout := strconv.Itoa(int(c.WriteTimeout.Seconds()))
 	readTimeout := strconv.Itoa(int(c.ReadTimeout.Seconds()))
 	c.WriteTimeout = Stosd(s.askForInput("Write timeout for HTTP and HTTPS client connections", "Default: " + writeTimeout, writeTimeout))
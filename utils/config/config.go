@@ -34,6 +34,7 @@ func DefaultConfig() *Config {
 		LetsEncryptEmail:    "",
 		LetsEncryptHosts:    "",
 
+		MMMode:             "url",
 		MMUserID:    		"",
 		MMLicenseKey:    	"",
 		MMProductID:        "GeoLite2-City",
@@ -42,12 +43,14 @@ func DefaultConfig() *Config {
 		MMUpdateInterval:   4 * time.Hour,
 		MMRetryInterval:    2 * time.Hour,
 
+		I2LMode:            "url",
 		I2LToken:    		"",
 		I2LProductID:       "PX8LITEBIN",
 		I2LUpdatesHost:     "www.ip2location.com",
 		I2LUpdateInterval:  4 * time.Hour,
 		I2LRetryInterval:   2 * time.Hour,
 
+		TorMode:            "url",
 		TorUpdatesHost:     "check.torproject.org",
 		TorUpdateInterval:  30 * time.Minute,
 		TorRetryInterval:   2 * time.Hour,
@@ -55,6 +58,7 @@ func DefaultConfig() *Config {
 
 		APIPrefix:           "/",
 		CORSOrigin:          "*",
+		XForwardedForHops:   1,
 		ReadTimeout:         30 * time.Second,
 		WriteTimeout:        15 * time.Second,
 		RateLimitLimit: 	 1,
@@ -64,8 +68,13 @@ func DefaultConfig() *Config {
 		RedisTimeout:        time.Second,
 		MemcacheAddr:        "localhost:11211",
 		MemcacheTimeout:     time.Second,
-		RateLimitBackend:    "redis",
+		RateLimitBackend:    "map",
 		RateLimitInterval:   3 * time.Minute,
+		RateLimitIPv4Prefix: 32,
+		RateLimitIPv6Prefix: 64,
+		RateLimitCosts:      map[string]int{"hostname": 1},
+		RateLimitStateInterval: time.Minute,
+		BatchLimit:          100,
 
 		RootDir: dir,
 		File: path.Join(dir, "conf", "settings.config"),
@@ -79,6 +88,8 @@ func (c *Config) AddFlags(fs *flag.FlagSet) {
 
 	fs.StringVar(&c.ServerAddr, 	"http", 	c.ServerAddr, 		"Address in form of ip:port to listen")
 	fs.StringVar(&c.TLSServerAddr, 	"https", 	c.TLSServerAddr, 	"Address in form of ip:port to listen")
+	fs.StringVar(&c.GRPCServerAddr, "grpc", 	c.GRPCServerAddr, 	"Address in form of ip:port to listen for gRPC requests")
+	fs.BoolVar(&c.GRPCTLS, 			"grpc-tls", c.GRPCTLS, 			"Enable TLS for the gRPC server using the -cert and -key files")
 
 	fs.BoolVar(&c.FastOpen, "tcp-fast-open", 	c.FastOpen, "Enable TCP fast open")
 	fs.BoolVar(&c.Naggle, 	"tcp-naggle", 	c.Naggle, 	"Enable TCP Nagle's algorithm (disables NO_DELAY)")
@@ -96,22 +107,38 @@ func (c *Config) AddFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.APIPrefix, 		"api-prefix", 			c.APIPrefix, 		"API endpoint prefix")
 	fs.StringVar(&c.CORSOrigin, 	"cors-origin", 			c.CORSOrigin, 		"Comma separated list of CORS origins endpoints")
 	fs.BoolVar(&c.UseXForwardedFor, "use-x-forwarded-for", 	c.UseXForwardedFor, "Use the X-Forwarded-For header when available (e.g. behind proxy)")
+	fs.IntVar(&c.XForwardedForHops, "x-forwarded-for-hops", c.XForwardedForHops, "Number of trusted proxies appending to the X-Forwarded-For header")
 
 	fs.StringVar(&c.GuiDir, "gui", c.GuiDir, "Web gui directory")
 
+	fs.StringVar(&c.MMMode, 			"mm-mode",			c.MMMode,			"MaxMind database source: url, file or disabled")
+	fs.StringVar(&c.MMFile, 			"mm-file",			c.MMFile,			"Local MaxMind database file used in file mode")
+	fs.StringVar(&c.MMASNFile, 			"mm-asn-file",		c.MMASNFile,		"Local MaxMind ASN database file used in file mode")
+	fs.StringVar(&c.MMConfigFile, 		"mm-config",		c.MMConfigFile,		"MaxMind geoipupdate GeoIP.conf file with credentials and editions")
+	fs.StringVar(&c.MMEditionIDs, 		"mm-edition-ids",	c.MMEditionIDs,		"Comma separated list of additional MaxMind editions to download")
+	fs.StringVar(&c.MMDatabaseDirectory, "mm-database-directory", c.MMDatabaseDirectory, "Directory the MaxMind databases are stored in (default is the cache directory)")
+	fs.StringVar(&c.MMProxy, 			"mm-proxy",			c.MMProxy,			"Proxy url used for MaxMind downloads")
 	fs.StringVar(&c.MMLicenseKey, 		"mm-license-key",		c.MMLicenseKey,		"MaxMind License Key")
-	fs.StringVar(&c.MMUserID, 			"mm-user-id",			c.MMUserID,			"MaxMind User ID (requires license-key)")
+	fs.StringVar(&c.MMUserID, 			"mm-user-id",			c.MMUserID,			"MaxMind Account ID, enables the account authenticated download (requires license-key)")
 	fs.StringVar(&c.MMProductID, 		"mm-product-id",		c.MMProductID,		"MaxMind Product ID (e.g GeoLite2-City)")
 	fs.DurationVar(&c.MMRetryInterval, 	"mm-retry",			c.MMRetryInterval,	"Max time to wait before retrying to download a MaxMind database")
 	fs.DurationVar(&c.MMUpdateInterval, "mm-update",			c.MMUpdateInterval,	"MaxMind database update check interval")
 	fs.StringVar(&c.MMUpdatesHost, 		"mm-updates-host",	c.MMUpdatesHost,	"MaxMind Updates Host")
 
+	fs.StringVar(&c.I2LMode, 				"i2l-mode",			c.I2LMode,				"ip2location database source: url, file or disabled")
+	fs.StringVar(&c.I2LFile, 				"i2l-file",			c.I2LFile,				"Local ip2location BIN file used in file mode")
+	fs.StringVar(&c.I2LChecksum, 			"i2l-checksum",		c.I2LChecksum,			"Url or file with the md5, sha1 or sha256 digest of the ip2location download")
 	fs.StringVar(&c.I2LToken, 				"i2l-token",			c.I2LToken,				"ip2location token")
 	fs.StringVar(&c.I2LProductID, 			"i2l-product-id",		c.I2LProductID,			"ip2location Product ID (e.g PX8LITEBIN)")
 	fs.DurationVar(&c.I2LRetryInterval, 	"i2l-retry",			c.I2LRetryInterval,		"Max time to wait before retrying to download a ip2location database")
 	fs.DurationVar(&c.I2LUpdateInterval, 	"i2l-update",			c.I2LUpdateInterval,	"ip2location database update check interval")
 	fs.StringVar(&c.I2LUpdatesHost, 		"i2l-updates-host",	c.I2LUpdatesHost,		"ip2location Updates Host")
 
+	fs.StringVar(&c.OverrideFile, 			"override",			c.OverrideFile,			"CSV or YAML file with networks overriding the databases, reloaded on change")
+
+	fs.StringVar(&c.TorMode, 				"tor-mode",			c.TorMode,				"Tor database source: url, file or disabled")
+	fs.StringVar(&c.TorFile, 				"tor-file",			c.TorFile,				"Local tor exit list used in file mode, one ip per line")
+	fs.StringVar(&c.TorChecksum, 			"tor-checksum",		c.TorChecksum,			"Url or file with the md5, sha1 or sha256 digest of the tor download")
 	fs.StringVar(&c.TorExitCheck, 			"tor-exit-check",		c.TorExitCheck,			"Tor exit check (e.g 8.8.8.8)")
 	fs.DurationVar(&c.TorRetryInterval, 	"tor-retry",			c.I2LRetryInterval,		"Max time to wait before retrying to download a tor database")
 	fs.DurationVar(&c.TorUpdateInterval, 	"tor-update",			c.I2LUpdateInterval,	"Tor database update check interval")
@@ -128,6 +155,17 @@ func (c *Config) AddFlags(fs *flag.FlagSet) {
 	fs.IntVar(&c.RateLimitBurst, 			"quota-burst", 	c.RateLimitBurst, 		"Max requests per source IP per request burst")
 	fs.DurationVar(&c.RateLimitInterval, 	"quota-interval", c.RateLimitInterval, 	"Quota expiration interval, per source IP querying the API")
 	fs.IntVar(&c.RateLimitLimit, 		   "quota-max", 		c.RateLimitLimit, 		"Max requests per source IP per interval; set 0 to turn quotas off")
+	fs.IntVar(&c.RateLimitIPv4Prefix, 	"quota-ipv4-prefix", c.RateLimitIPv4Prefix, "IPv4 prefix length of the subnet sharing one quota")
+	fs.IntVar(&c.RateLimitIPv6Prefix, 	"quota-ipv6-prefix", c.RateLimitIPv6Prefix, "IPv6 prefix length of the subnet sharing one quota")
+	fs.StringVar(&c.RateLimitStateFile, 		"quota-state", 			c.RateLimitStateFile, 		"File the map quota backend is saved to and restored from")
+	fs.DurationVar(&c.RateLimitStateInterval, 	"quota-state-interval", c.RateLimitStateInterval, 	"Interval at which the map quota backend is saved")
+
+	fs.StringVar(&c.APIKeysFile, 		"api-keys", 		c.APIKeysFile, 		"JSON file with api keys and their quotas, reloaded on change")
+	fs.BoolVar(&c.APIKeyRequired, 		"api-key-required", c.APIKeyRequired, 	"Reject requests without api key")
+	fs.StringVar(&c.AccessListFile, 	"access-list", 		c.AccessListFile, 	"JSON file with denied and allowed networks, reloaded on change")
+	fs.StringVar(&c.AdminToken, 		"admin-token", 		c.AdminToken, 		"Bearer token required by the admin endpoints; admin endpoints are disabled if empty")
+
+	fs.IntVar(&c.BatchLimit, 		"batch-max", 		c.BatchLimit, 		"Max number of ips or hosts per batch request")
 
 	fs.DurationVar(&c.ReadTimeout, 	"read-timeout",	c.ReadTimeout, 	"Read timeout for HTTP and HTTPS client connections")
 	fs.StringVar(&c.RedisAddr, 		"redis", 			c.RedisAddr, 	"Redis address in form of host:port[,host:port] for quota")
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ApplyGeoIPConf loads the GeoIP.conf file configured by MMConfigFile, if
+// any. Flags set on fs keep their value, so the settings are applied on
+// top of the config file but below the command line.
+func (c *Config) ApplyGeoIPConf(fs *flag.FlagSet) error {
+	if c.MMConfigFile == "" {
+		return nil
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	if err := c.LoadGeoIPConf(c.MMConfigFile); err != nil {
+		return err
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadGeoIPConf applies the settings of a MaxMind geoipupdate GeoIP.conf
+// file, which take precedence over the MaxMind settings of the config.
+// The City or Country edition and the ASN edition are used for lookups,
+// all other editions are downloaded as well.
+func (c *Config) LoadGeoIPConf(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var editions []string
+	var proxy, proxyAuth string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key, value := fields[0], strings.Join(fields[1:], " ")
+		switch key {
+		case "AccountID", "UserId":
+			c.MMUserID = value
+		case "LicenseKey":
+			c.MMLicenseKey = value
+		case "EditionIDs", "ProductIds":
+			editions = fields[1:]
+		case "DatabaseDirectory":
+			c.MMDatabaseDirectory = value
+		case "Host":
+			c.MMUpdatesHost = strings.TrimPrefix(strings.TrimPrefix(value, "https://"), "http://")
+		case "Proxy":
+			proxy = value
+		case "ProxyUserPassword":
+			proxyAuth = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(editions) > 0 {
+		c.setEditions(editions)
+	}
+	if proxy != "" {
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy %s", proxy)
+		}
+		if proxyAuth != "" {
+			user := strings.SplitN(proxyAuth, ":", 2)
+			if len(user) == 2 {
+				u.User = url.UserPassword(user[0], user[1])
+			} else {
+				u.User = url.User(user[0])
+			}
+		}
+		c.MMProxy = u.String()
+	}
+	return nil
+}
+
+// setEditions picks the editions used for lookups, a City edition is
+// preferred over a Country edition.
+func (c *Config) setEditions(editions []string) {
+	var city, country, asn string
+	var other []string
+	for _, id := range editions {
+		switch {
+		case strings.HasSuffix(id, "-City") && city == "":
+			city = id
+		case strings.HasSuffix(id, "-Country") && country == "":
+			country = id
+		case strings.HasSuffix(id, "-ASN") && asn == "":
+			asn = id
+		default:
+			other = append(other, id)
+		}
+	}
+	if city == "" {
+		city = country
+	} else if country != "" {
+		other = append(other, country)
+	}
+	if city != "" {
+		c.MMProductID = city
+	}
+	if asn != "" {
+		c.MMASNProductID = asn
+	}
+	c.MMEditionIDs = strings.Join(other, ",")
+}
+
+// Editions returns the additional MaxMind editions, which are downloaded
+// besides the editions used for lookups.
+func (c *Config) Editions() []string {
+	var editions []string
+	for _, id := range strings.FieldsFunc(c.MMEditionIDs, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if id != c.MMProductID && id != c.MMASNProductID {
+			editions = append(editions, id)
+		}
+	}
+	return editions
+}
@@ -10,6 +10,17 @@ type Build struct {
 	Version string	 `json:"version"`
 }
 
+// QuotaPolicy assigns limiter settings to clients whose own lookup matches
+// all entries of Match. Keys are dotted json paths of the response record,
+// values are a single value or a list of alternatives.
+type QuotaPolicy struct {
+	Name   string                 `json:"name"`
+	Match  map[string]interface{} `json:"match"`
+	Action string                 `json:"action"` // limit, allow or block
+	Limit  int                    `json:"limit"`
+	Burst  int                    `json:"burst"`
+}
+
 type Config struct {
 	Build    			Build  		  `json:"build"`
 
@@ -23,6 +34,9 @@ type Config struct {
 	TLSCertFile         string        `json:"CERT"`
 	TLSKeyFile          string        `json:"KEY"`
 
+	GRPCServerAddr      string        `json:"GRPC"`
+	GRPCTLS             bool          `json:"GRPC_TLS"`
+
 	LetsEncrypt         bool          `json:"LETSENCRYPT"`
 	LetsEncryptCacheDir string        `json:"LETSENCRYPT_CERT_DIR"`
 	LetsEncryptEmail    string        `json:"LETSENCRYPT_EMAIL"`
@@ -33,6 +47,7 @@ type Config struct {
 	ReadTimeout         time.Duration `json:"READ_TIMEOUT"`
 	WriteTimeout        time.Duration `json:"WRITE_TIMEOUT"`
 	UseXForwardedFor    bool          `json:"USE_X_FORWARDED_FOR"`
+	XForwardedForHops   int           `json:"X_FORWARDED_FOR_HOPS"`
 	Silent              bool          `json:"SILENT"`
 	LogToStdout         bool          `json:"LOG_STDOUT"`
 	LogOutputFile       string        `json:"LOG_FILE"`
@@ -46,7 +61,27 @@ type Config struct {
 	RateLimitInterval   time.Duration `json:"QUOTA_INTERVAL"`
 	RateLimitLimit      int           `json:"QUOTA_MAX"`
 	RateLimitBurst      int           `json:"QUOTA_BURST"`
+	RateLimitIPv4Prefix int           `json:"QUOTA_IPV4_PREFIX"`
+	RateLimitIPv6Prefix int           `json:"QUOTA_IPV6_PREFIX"`
+	RateLimitPolicies   []QuotaPolicy `json:"QUOTA_POLICIES"`
+	RateLimitCosts      map[string]int `json:"QUOTA_COSTS"`
+	RateLimitStateFile  string        `json:"QUOTA_STATE_FILE"`
+	RateLimitStateInterval time.Duration `json:"QUOTA_STATE_INTERVAL"`
 
+	APIKeysFile         string        `json:"API_KEYS"`
+	APIKeyRequired      bool          `json:"API_KEY_REQUIRED"`
+	AccessListFile      string        `json:"ACCESS_LIST"`
+	AdminToken          string        `json:"ADMIN_TOKEN"`
+
+	BatchLimit          int           `json:"BATCH_MAX"`
+
+	MMMode              string        `json:"MM_MODE"`
+	MMFile              string        `json:"MM_FILE"`
+	MMASNFile           string        `json:"MM_ASN_FILE"`
+	MMConfigFile        string        `json:"MM_CONFIG"`
+	MMEditionIDs        string        `json:"MM_EDITION_IDS"`
+	MMDatabaseDirectory string        `json:"MM_DATABASE_DIRECTORY"`
+	MMProxy             string        `json:"MM_PROXY"`
 	MMUserID            string        `json:"MM_USER_ID"`
 	MMLicenseKey        string        `json:"MM_LICENSE_KEY"`
 	MMProductID         string        `json:"MM_PRODUCT_ID"`
@@ -55,17 +90,25 @@ type Config struct {
 	MMRetryInterval     time.Duration `json:"MM_RETRY_INTERVAL"`
 	MMUpdateInterval    time.Duration `json:"MM_UPDATE_INTERVAL"`
 
+	I2LMode             string        `json:"I2L_MODE"`
+	I2LFile             string        `json:"I2L_FILE"`
+	I2LChecksum         string        `json:"I2L_CHECKSUM"`
 	I2LToken			string		  `json:"I2L_TOKEN"`
 	I2LProductID		string		  `json:"I2L_PRODUCT_ID"`
 	I2LRetryInterval    time.Duration `json:"I2L_RETRY_INTERVAL"`
 	I2LUpdateInterval   time.Duration `json:"I2L_UPDATE_INTERVAL"`
 	I2LUpdatesHost      string        `json:"I2L_UPDATES_HOST"`
 
+	TorMode             string        `json:"TOR_MODE"`
+	TorFile             string        `json:"TOR_FILE"`
+	TorChecksum         string        `json:"TOR_CHECKSUM"`
 	TorExitCheck      	string 		  `json:"TOR_EXIT"`
 	TorRetryInterval    time.Duration `json:"TOR_RETRY_INTERVAL"`
 	TorUpdateInterval   time.Duration `json:"TOR_UPDATE_INTERVAL"`
 	TorUpdatesHost      string        `json:"TOR_UPDATES_HOST"`
 
+	OverrideFile        string        `json:"OVERRIDE_FILE"`
+
 	GuiDir              string        `json:"GUI"`
 
 	File     			string 		  `json:"-"`
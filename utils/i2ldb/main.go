@@ -19,6 +19,9 @@ type Config struct {
 	DB          	map[string]bool
 }
 
+// probeIP is looked up to validate a new database.
+const probeIP = "8.8.8.8"
+
 type ProxyDefaultQuery struct {
 	Isp string
 	ProxyType string
@@ -40,18 +43,23 @@ func NewDefaultConfig(c *config.Config) *Config {
 		dbFile, dbArchive,
 		conf.GenerateUpdateURL(),
 		conf.newReader)
+	conf.Updater.Checksum = c.I2LChecksum
+	conf.Updater.Mode = c.I2LMode
+	if c.I2LMode == updater.ModeFile {
+		conf.Updater.UseFile(c.I2LFile)
+	}
 
 	return conf
 }
 
 // Generate the update url for the current product database.
 func (c *Config) GenerateUpdateURL() string {
-	u := "https://www.ip2location.com/download/?token="+ c.Config.I2LToken+"&file=" + c.Config.I2LProductID
+	u := "https://" + c.Config.I2LUpdatesHost + "/download/?token="+ c.Config.I2LToken+"&file=" + c.Config.I2LProductID
 	return u
 }
 
 func (c *Config) Start() (*updater.Config, error){
-	return c.Updater.OpenURL()
+	return c.Updater.Start()
 }
 
 func (c *Config) newReader() error {
@@ -66,33 +74,48 @@ func (c *Config) newReader() error {
 		return err
 	}
 
-	err, _ = c.Updater.ProcessFile()
-	if err != nil {
+	if err := c.Updater.Install(c.validate); err != nil {
+		c.Updater.SendError(err)
 		return err
 	}
 
-	_, err = os.Open(c.Updater.File)
-	if err != nil {
-		return err
+	c.Updater.Mu.Lock()
+	defer c.Updater.Mu.Unlock()
+	if c.Updater.Closed {
+		return nil
 	}
 
+	ip2proxy.Close()
 	if ip2proxy.Open(c.Updater.File) != 0 {
 		err := fmt.Errorf("DB failed to load")
 		c.Updater.SendError(err)
 		return err
 	}
 
+	select {
+	case c.Updater.Notifier.Open <- c.Updater.File:
+	default:
+	}
+	return nil
+}
+
+// validate opens the database file and probes a lookup of a known ip.
+// The library serves a single database only, so the database in place is
+// opened again afterwards.
+func (c *Config) validate(file string) error {
 	c.Updater.Mu.Lock()
 	defer c.Updater.Mu.Unlock()
-	if c.Updater.Closed {
+	defer func() {
 		ip2proxy.Close()
-		return nil
-	}
+		ip2proxy.Open(c.Updater.File)
+	}()
 
-	c.Updater.LastUpdated = stat.ModTime().UTC()
-	select {
-	case c.Updater.Notifier.Open <- c.Updater.File:
-	default:
+	ip2proxy.Close()
+	if ip2proxy.Open(file) != 0 {
+		return fmt.Errorf("DB failed to load")
+	}
+	if i, err := strconv.Atoi(ip2proxy.GetAll(probeIP)["isProxy"]); err != nil || i < 0 {
+		return fmt.Errorf("DB failed to answer a lookup")
 	}
 	return nil
 }
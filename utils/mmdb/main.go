@@ -2,10 +2,12 @@ package mmdb
 
 import (
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"net"
-	"os"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/oschwald/maxminddb-golang"
@@ -14,57 +16,99 @@ import (
 	"../updater"
 )
 
-func NewDefaultConfig(c *config.Config, productID string) *DB {
+func NewDefaultConfig(c *config.Config, productID string, file string) *DB {
 	conf := &DB{
 		Config: c,
+		productID: productID,
 		ErrUnavailable: errors.New("no database available"),
 	}
+	dbFile := filepath.Join(c.RootDir, "cache", productID + ".mmdb")
+	if c.MMDatabaseDirectory != "" {
+		// Same file names as geoipupdate, so the directory can be shared
+		dbFile = filepath.Join(c.MMDatabaseDirectory, productID + ".mmdb")
+	}
 	conf.Updater = updater.NewDefaultConfig(c.MMUpdateInterval, c.MMRetryInterval,
-		filepath.Join(c.RootDir, "cache", productID + ".mmdb"),
+		dbFile,
 		filepath.Join(c.RootDir, "cache", productID + ".tar.gz"),
 		conf.GenerateUpdateURL(productID), conf.newReader)
+	// MaxMind publishes the digest of every archive under the .sha256 suffix
+	conf.Updater.Checksum = conf.GenerateUpdateURL(productID) + ".sha256"
+	conf.Updater.Mode = c.MMMode
+	if c.MMMode == updater.ModeFile {
+		if file == "" && c.MMDatabaseDirectory != "" {
+			file = dbFile
+		}
+		conf.Updater.UseFile(file)
+	}
+	if c.MMProxy != "" {
+		if proxy, err := url.Parse(c.MMProxy); err == nil {
+			conf.Updater.Client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+		}
+	}
 
 	return conf
 }
 
-// Generate the update url for the current product database.
+// Generate the update url for the current product database. Downloads
+// of accounts are authenticated with the account id and license key,
+// otherwise the license key is sent as parameter.
 func (d *DB) GenerateUpdateURL(productID string) string {
+	if d.Config.MMUserID != "" {
+		u := &url.URL{
+			Scheme:   "https",
+			User:     url.UserPassword(d.Config.MMUserID, d.Config.MMLicenseKey),
+			Host:     d.Config.MMUpdatesHost,
+			Path:     "/geoip/databases/" + productID + "/download",
+			RawQuery: "suffix=tar.gz",
+		}
+		return u.String()
+	}
 	u := "https://" + d.Config.MMUpdatesHost + "/app/" + "geoip_download?edition_id=" + productID +
 		"&date=&license_key=" + d.Config.MMLicenseKey + "&suffix=tar.gz"
 	return u
 }
 
+// ProductID returns the edition of the database.
+func (d *DB) ProductID() string {
+	return d.productID
+}
+
 func (d *DB) Start() (*updater.Config, error){
-	return d.Updater.OpenURL()
+	return d.Updater.Start()
 }
 
 func (d *DB) newReader() error {
-	err, dbFileName := d.Updater.ProcessFile()
-	if err != nil {
+	if err := d.Updater.Install(d.validate); err != nil {
 		return err
 	}
 
-	f, err := os.Open(dbFileName)
+	reader, err := maxminddb.Open(d.Updater.File)
 	if err != nil {
 		return err
 	}
+	d.setReader(reader)
+	return nil
+}
 
-	if _, err := ioutil.ReadAll(f); err != nil {
-		return err
-	}
-	reader, err := maxminddb.Open(dbFileName)
+// validate verifies the structure of the database file and checks that
+// it contains the configured product.
+func (d *DB) validate(file string) error {
+	reader, err := maxminddb.Open(file)
 	if err != nil {
 		return err
 	}
-	stat, err := os.Stat(d.Updater.Archive)
-	if err != nil {
+	defer reader.Close()
+
+	if err := reader.Verify(); err != nil {
 		return err
 	}
-	d.setReader(reader, stat.ModTime())
+	if !strings.EqualFold(reader.Metadata.DatabaseType, d.productID) {
+		return fmt.Errorf("unexpected database type %s, expected %s", reader.Metadata.DatabaseType, d.productID)
+	}
 	return nil
 }
 
-func (d *DB) setReader(reader *maxminddb.Reader, modtime time.Time) {
+func (d *DB) setReader(reader *maxminddb.Reader) {
 	d.Updater.Mu.Lock()
 	defer d.Updater.Mu.Unlock()
 	if d.Updater.Closed {
@@ -75,7 +119,7 @@ func (d *DB) setReader(reader *maxminddb.Reader, modtime time.Time) {
 		if err := d.reader.Close(); err != nil {}
 	}
 	d.reader = reader
-	d.Updater.LastUpdated = modtime.UTC()
+	d.Updater.Built = time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC()
 	select {
 	case d.Updater.Notifier.Open <- d.Updater.File:
 	default:
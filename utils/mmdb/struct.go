@@ -9,6 +9,7 @@ import (
 // DB is the IP geolocation database.
 type DB struct {
 	reader      		*maxminddb.Reader 	// Actual db object.
+	productID   		string 				// Expected database type
 
 	Updater 			*updater.Config			// Holds all notification channels
 	Config 				*config.Config		// Shared default configuration
@@ -39,8 +40,8 @@ type DefaultQuery struct {
 		Names map[string]string `maxminddb:"names"`
 	} `maxminddb:"city"`
 	Location struct {
-		Latitude  float64 `maxminddb:"latitude"`
-		Longitude float64 `maxminddb:"longitude"`
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
 		AccuracyRadius uint `maxminddb:"accuracy_radius"`
 		MetroCode uint    `maxminddb:"metro_code"`
 		TimeZone  string  `maxminddb:"time_zone"`
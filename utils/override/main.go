@@ -0,0 +1,237 @@
+package override
+
+import (
+	"../config"
+	"../updater"
+	"encoding/csv"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record holds the values of a network which replace the values of the
+// regular databases. Empty values are not replaced.
+type Record struct {
+	Network     string            `yaml:"network"`
+	CountryCode string            `yaml:"country_code"`
+	RegionCode  string            `yaml:"region_code"`
+	RegionName  string            `yaml:"region_name"`
+	City        string            `yaml:"city"`
+	ZipCode     string            `yaml:"zip_code"`
+	TimeZone    string            `yaml:"time_zone"`
+	Latitude    *float64          `yaml:"latitude"`
+	Longitude   *float64          `yaml:"longitude"`
+	ASN         uint              `yaml:"asn"`
+	ASName      string            `yaml:"as_name"`
+	ISP         string            `yaml:"isp"`
+	Domain      string            `yaml:"domain"`
+	Tags        []string          `yaml:"tags"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// Config is the override database. Networks are matched by longest
+// prefix, so nested networks override the networks containing them.
+type Config struct {
+	Updater *updater.Config // Holds all notification channels
+	Config  *config.Config  // Shared default configuration
+
+	networks map[string]*Record // Records by network in CIDR notation
+	prefixes [2][]int           // Prefix lengths in use for IPv4 and IPv6, longest first
+}
+
+func NewDefaultConfig(c *config.Config) *Config {
+	conf := &Config{
+		Config: c,
+	}
+	file, _ := filepath.Abs(c.OverrideFile)
+	conf.Updater = updater.NewDefaultConfig(0, 0, file, file, "", conf.newReader)
+
+	return conf
+}
+
+// Start loads the database and reloads it whenever the file changes.
+func (c *Config) Start() (*updater.Config, error) {
+	return c.Updater, c.Updater.Open()
+}
+
+// Lookup returns the record of the most specific network containing the
+// address or nil if there is none.
+func (c *Config) Lookup(addr net.IP) *Record {
+	c.Updater.Mu.RLock()
+	defer c.Updater.Mu.RUnlock()
+
+	bits, family := 128, 1
+	if ip4 := addr.To4(); ip4 != nil {
+		addr, bits, family = ip4, 32, 0
+	}
+	for _, prefix := range c.prefixes[family] {
+		mask := net.CIDRMask(prefix, bits)
+		network := &net.IPNet{IP: addr.Mask(mask), Mask: mask}
+		if r, ok := c.networks[network.String()]; ok {
+			return r
+		}
+	}
+	return nil
+}
+
+func (c *Config) newReader() error {
+	f, err := os.Open(c.Updater.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []*Record
+	switch strings.ToLower(filepath.Ext(c.Updater.File)) {
+	case ".csv":
+		records, err = readCSV(f)
+	case ".yaml", ".yml":
+		records, err = readYAML(f)
+	default:
+		err = fmt.Errorf("unknown override file format: %s", c.Updater.File)
+	}
+	if err != nil {
+		c.Updater.SendError(err)
+		return err
+	}
+
+	networks := make(map[string]*Record, len(records))
+	lengths := [2]map[int]bool{{}, {}}
+	for _, r := range records {
+		_, network, err := net.ParseCIDR(r.Network)
+		if err != nil {
+			err = fmt.Errorf("invalid override network: %s", r.Network)
+			c.Updater.SendError(err)
+			return err
+		}
+		r.Network = network.String()
+		networks[r.Network] = r
+
+		ones, bits := network.Mask.Size()
+		if bits == 32 {
+			lengths[0][ones] = true
+		} else {
+			lengths[1][ones] = true
+		}
+	}
+
+	var prefixes [2][]int
+	for family := range lengths {
+		for prefix := range lengths[family] {
+			prefixes[family] = append(prefixes[family], prefix)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(prefixes[family])))
+	}
+
+	c.Updater.Mu.Lock()
+	c.networks, c.prefixes = networks, prefixes
+	c.Updater.Mu.Unlock()
+
+	select {
+	case c.Updater.Notifier.Open <- c.Updater.File:
+	default:
+	}
+	return nil
+}
+
+func readYAML(r io.Reader) ([]*Record, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	if err := yaml.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// readCSV reads a csv file with a header row. The columns are named like
+// the yaml keys. Tags are separated by "|", all unknown columns are added
+// as labels.
+func readCSV(r io.Reader) ([]*Record, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := &Record{Labels: map[string]string{}}
+		for i, value := range row {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			if err := record.set(strings.TrimSpace(header[i]), value); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (r *Record) set(column string, value string) error {
+	switch column {
+	case "network":
+		r.Network = value
+	case "country_code":
+		r.CountryCode = value
+	case "region_code":
+		r.RegionCode = value
+	case "region_name":
+		r.RegionName = value
+	case "city":
+		r.City = value
+	case "zip_code":
+		r.ZipCode = value
+	case "time_zone":
+		r.TimeZone = value
+	case "latitude", "longitude":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", column, value)
+		}
+		if column == "latitude" {
+			r.Latitude = &f
+		} else {
+			r.Longitude = &f
+		}
+	case "asn":
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid asn: %s", value)
+		}
+		r.ASN = uint(n)
+	case "as_name":
+		r.ASName = value
+	case "isp":
+		r.ISP = value
+	case "domain":
+		r.Domain = value
+	case "tags":
+		r.Tags = strings.Split(value, "|")
+	default:
+		r.Labels[column] = value
+	}
+	return nil
+}
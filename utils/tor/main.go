@@ -27,12 +27,17 @@ func NewDefaultConfig(c *config.Config) *Config {
 		dbFile, dbFile,
 		"https://" + c.TorUpdatesHost +"/cgi-bin/TorBulkExitList.py?ip=" + c.TorExitCheck,
 		conf.newReader)
+	conf.Updater.Checksum = c.TorChecksum
+	conf.Updater.Mode = c.TorMode
+	if c.TorMode == updater.ModeFile {
+		conf.Updater.UseFile(c.TorFile)
+	}
 
 	return conf
 }
 
 func (c *Config) Start() (*updater.Config, error){
-	return c.Updater.OpenURL()
+	return c.Updater.Start()
 }
 
 func (c *Config) Lookup(addr net.IP) bool {
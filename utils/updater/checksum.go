@@ -0,0 +1,85 @@
+package updater
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// verify compares the digest of the downloaded file with the published
+// checksum. The algorithm is chosen by the length of the checksum, md5,
+// sha1 and sha256 are supported. Nothing is verified if no checksum is
+// configured.
+func (c *Config) verify(file string) error {
+	if c.Checksum == "" {
+		return nil
+	}
+	expected, err := c.readChecksum()
+	if err != nil {
+		return fmt.Errorf("checksum not available: %s", err)
+	}
+
+	var h hash.Hash
+	switch len(expected) {
+	case md5.Size * 2:
+		h = md5.New()
+	case sha1.Size * 2:
+		h = sha1.New()
+	case sha256.Size * 2:
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unknown checksum format: %s", expected)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// readChecksum reads the checksum from a url or a local file. Checksum
+// files may contain the file name after the digest, like the output of
+// sha256sum.
+func (c *Config) readChecksum() (string, error) {
+	var content []byte
+	var err error
+	if strings.HasPrefix(c.Checksum, "http://") || strings.HasPrefix(c.Checksum, "https://") {
+		var resp *http.Response
+		resp, err = c.client().Get(c.Checksum)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		content, err = ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	} else {
+		content, err = ioutil.ReadFile(c.Checksum)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum")
+	}
+	return fields[0], nil
+}
@@ -4,11 +4,12 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func MakeDir(filename string) (dbdir string, err error) {
@@ -31,21 +32,56 @@ func RenameFile(fromName string, toName string) error {
 	return os.Rename(fromName, toName)
 }
 
+// ProcessFile extracts the database from the archive into a temporary
+// file next to the database and returns its name. Local database files
+// are not extracted, their own name is returned.
 func (c *Config) ProcessFile() (error, string) {
+	if c.Archive == c.File {
+		// Local database files are used as they are
+		return nil, c.File
+	}
 	f, err := os.Open(c.Archive)
 	if err != nil {
 		return err, ""
 	}
 	defer f.Close()
+	if _, err := MakeDir(c.File); err != nil {
+		return err, ""
+	}
 
-	err, _ = c.ExtractTarGz(f)
+	tmpFile := fmt.Sprintf(c.File + "%d", time.Now().UnixNano())
+	err, _ = c.ExtractTarGz(f, tmpFile)
 	if err != nil {
-		err, _ = c.ExtractZip()
+		err, _ = c.ExtractZip(tmpFile)
+	}
+	if err != nil {
+		if err := os.RemoveAll(tmpFile); err != nil {}
+		return err, ""
 	}
-	return err, c.File
+	return nil, tmpFile
 }
 
-func (c *Config) ExtractZip() (error, string) {
+// Install extracts the archive, validates the database and moves it into
+// place. The database in place is left untouched if anything fails, so
+// readers of the previous database keep working.
+func (c *Config) Install(validate func(file string) error) error {
+	err, tmpFile := c.ProcessFile()
+	if err != nil {
+		return err
+	}
+	if err := validate(tmpFile); err != nil {
+		if tmpFile != c.File {
+			if err := os.RemoveAll(tmpFile); err != nil {}
+		}
+		return err
+	}
+	if tmpFile == c.File {
+		return nil
+	}
+	return os.Rename(tmpFile, c.File)
+}
+
+func (c *Config) ExtractZip(dest string) (error, string) {
 	r, err := zip.OpenReader(c.Archive)
 	if err != nil {
 		return err, ""
@@ -54,46 +90,25 @@ func (c *Config) ExtractZip() (error, string) {
 		if err := r.Close(); err != nil {}
 	}()
 
-	// Closure to address file descriptors issue with all the deferred .Close() methods
-	extractAndWriteFile := func(f *zip.File) error {
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !(strings.Contains(f.Name, "mmdb") || strings.Contains(f.Name, "BIN")) {
+			continue
+		}
 		rc, err := f.Open()
 		if err != nil {
-			return err
-		}
-		defer func() {
-			if err := rc.Close(); err != nil {
-				// panic(err)
-			}
-		}()
-
-		if f.FileInfo().IsDir() == false {
-			if strings.Contains(f.Name, "mmdb") || strings.Contains(f.Name, "BIN") {
-				outFile, err := os.Create(c.File)
-				if err != nil {
-					return err
-				}
-				defer outFile.Close()
-				if _, err := io.Copy(outFile, rc); err != nil {
-					return err
-				}else{
-					return nil
-				}
-			}
+			return err, ""
 		}
-		return nil
-	}
-
-	for _, f := range r.File {
-		err := extractAndWriteFile(f)
-		if err != nil {
-			break
+		defer rc.Close()
+		if err := writeFile(dest, rc); err != nil {
+			return err, ""
 		}
+		return nil, dest
 	}
 
-	return nil, c.File
+	return fmt.Errorf("no database found in %s", c.Archive), ""
 }
 
-func (c *Config) ExtractTarGz(gzipStream io.Reader) (error, string) {
+func (c *Config) ExtractTarGz(gzipStream io.Reader, dest string) (error, string) {
 	uncompressedStream, err := gzip.NewReader(gzipStream)
 	if err != nil {
 		return err, ""
@@ -117,22 +132,27 @@ func (c *Config) ExtractTarGz(gzipStream io.Reader) (error, string) {
 			break;
 		case tar.TypeReg:
 			if strings.Contains(header.Name, "mmdb") || strings.Contains(header.Name, "BIN") {
-				outFile, err := os.Create(c.File)
-				if err != nil {
-					return err, ""
-				}
-				defer outFile.Close()
-				if _, err := io.Copy(outFile, tarReader); err != nil {
+				if err := writeFile(dest, tarReader); err != nil {
 					return err, ""
-				}else{
-					return nil, c.File
 				}
+				return nil, dest
 			}
 		default:
-			log.Fatalf(
-				"ExtractTarGz: uknown type: %b in %s", header.Typeflag, header.Name)
+			return fmt.Errorf("ExtractTarGz: unknown type: %b in %s", header.Typeflag, header.Name), ""
 		}
 	}
 
-	return nil, ""
+	return fmt.Errorf("no database found in %s", c.Archive), ""
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -7,21 +7,36 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
+// Modes a database can be started with.
+const (
+	ModeURL      = "url"      // Download the database and keep it up to date
+	ModeFile     = "file"     // Load a local file and reload it on change
+	ModeDisabled = "disabled" // Never load the database
+)
+
 type Config struct {
 
 	Notifier       *Notifier // Holds all notification channels
+	Mode           string    // One of the modes above, url if empty
 	File           string
 	Archive        string
+	Checksum       string    // Url or file with the digest of the download, not verified if empty
+	Client         *http.Client // Client used for all downloads, http.DefaultClient if nil
 	Closed         bool // Mark this db as closed.
 	UpdateInterval time.Duration
 	RetryInterval  time.Duration
-	LastUpdated    time.Time    // Last time the db was updated.
+	LastUpdated    time.Time    // Last-Modified date of the download or modification time of the file.
+	Built          time.Time    // Build date stored in the db itself, zero if unknown.
+	Downloaded     time.Time    // Last time the db was downloaded.
 	updateUrl      string       // tor project update url
 	Mu             sync.RWMutex // Protects all the above.
+	swap           sync.Mutex   // Serializes loading and replacing the archive
+	pending        *meta        // Validators of a download which has not been loaded yet, guarded by swap
 
 	cbk          	func() error
 }
@@ -43,6 +58,39 @@ func NewDefaultConfig(UpdateInterval time.Duration, RetryInterval time.Duration,
 	}
 }
 
+// UseFile points the database to a local file, which is loaded as is
+// instead of being extracted from a downloaded archive.
+func (c *Config) UseFile(file string) {
+	if file != "" {
+		file, _ = filepath.Abs(file)
+	}
+	c.File = file
+	c.Archive = file
+}
+
+// Start opens the database according to its mode. Neither a database in
+// file mode nor a disabled database is ever downloaded. The config is
+// returned even if an error occurred.
+func (c *Config) Start() (*Config, error) {
+	switch c.Mode {
+	case ModeURL, "":
+		return c.OpenURL()
+	case ModeFile:
+		if c.File == "" {
+			return c, fmt.Errorf("no database file configured")
+		}
+		return c, c.Open()
+	case ModeDisabled:
+		return c, nil
+	}
+	return c, fmt.Errorf("unknown database mode: %s", c.Mode)
+}
+
+// Enabled reports whether the database is meant to be loaded at all.
+func (c *Config) Enabled() bool {
+	return c.Mode != ModeDisabled
+}
+
 // Open creates and initializes a DB from a local file.
 //
 // The database file is monitored by fsnotify and automatically
@@ -65,13 +113,15 @@ func (c *Config) Open() error {
 // It automatically downloads and updates the file in background, and
 // keeps a local copy on $TMPDIR.
 func (c *Config) OpenURL() (*Config, error) {
-	// Optional, might fail.
-	if err := c.openFile(); err != nil {}
+	// Optional, there is no local copy before the first download.
+	if _, err := os.Stat(c.Archive); err == nil {
+		c.reload()
+	}
 
 	go c.autoUpdate()
 	if err := c.watchFile(); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("fsnotify failed for %s: %s", c.File, err)
+		return c, fmt.Errorf("fsnotify failed for %s: %s", c.File, err)
 	}
 	return c, nil
 }
@@ -80,7 +130,7 @@ func (c *Config) autoUpdate() {
 	backoff := time.Second
 	for {
 		c.SendInfo("Checking for updates")
-		err := c.runUpdate(c.updateUrl)
+		err := c.Update()
 		if err != nil {
 			bs := backoff.Seconds()
 			ms := c.RetryInterval.Seconds()
@@ -98,80 +148,80 @@ func (c *Config) autoUpdate() {
 	}
 }
 
+// Update downloads the archive if it changed since the last download.
+func (c *Config) Update() error {
+	return c.runUpdate(c.updateUrl)
+}
+
 func (c *Config) runUpdate(url string) error {
-	yes, err := c.needUpdate(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	c.conditional(req)
+	resp, err := c.client().Do(req)
 	if err != nil {
 		return err
 	}
-	if !yes {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
 		c.SendInfo("DB is up to date")
 		return nil
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
 	c.SendInfo("starting update")
-	tmpFile, err := c.download(url)
+	tmpFile, err := c.download(resp.Body)
 	if err != nil {
 		return err
 	}
-	err = RenameFile(tmpFile, c.Archive)
-	if err != nil {
-		// Cleanup the temp file if renaming failed.
+	if err := c.verify(tmpFile); err != nil {
+		// Keep the previous database
 		if err := os.RemoveAll(tmpFile); err != nil {}
+		return err
 	}
-	return err
-}
-
-func (c *Config) needUpdate(url string) (bool, error) {
-	stat, err := os.Stat(c.File)
-	if err != nil {
-		return true, nil // Local db is missing, must be downloaded.
-	}
-
-	if time.Now().Sub(stat.ModTime()) < c.UpdateInterval / 12 {
-		return false, nil
-	}
-
-	resp, err := http.Head(url)
-	if err != nil {
-		return false, err
+	c.swap.Lock()
+	defer c.swap.Unlock()
+	if err := RenameFile(tmpFile, c.Archive); err != nil {
+		// Cleanup the temp file if renaming failed.
+		if err := os.RemoveAll(tmpFile); err != nil {}
+		return err
 	}
-	defer resp.Body.Close()
-
-	// Check X-Database-MD5 if it exists
-	LastModified := resp.Header.Get("Last-Modified")
-	if LastModified != "" {
-		t, err := time.Parse(http.TimeFormat, LastModified)
-		if err != nil {
-			return false, err
-		}
-		if t.After(stat.ModTime()) {
-			return true, nil
-		}
+	// The validators are stored once the archive has been loaded, so a
+	// rejected archive is downloaded again by the next update check.
+	c.pending = &meta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Downloaded:   time.Now().UTC(),
 	}
-
-	err = os.Chtimes(c.File, time.Now(), time.Now())
-
-	return false, nil
+	return nil
 }
 
-func (c *Config) download(url string) (tmpFile string, err error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+func (c *Config) download(body io.Reader) (tmpFile string, err error) {
 	tmpFile = fmt.Sprintf(c.File + "%d", time.Now().UnixNano())
 	f, err := os.Create(tmpFile)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
+	_, err = io.Copy(f, body)
 	if err != nil {
+		if err := os.RemoveAll(tmpFile); err != nil {}
 		return "", err
 	}
 	return tmpFile, nil
 }
 
+func (c *Config) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
 func (c *Config) watchFile() error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -190,7 +240,7 @@ func (c *Config) watchEvents(watcher *fsnotify.Watcher) {
 		select {
 		case ev := <-watcher.Event:
 			if ev.Name == c.Archive && (ev.IsCreate() || ev.IsModify()) {
-				if err := c.openFile(); err != nil {}
+				c.reload()
 			}
 		case <-watcher.Error:
 		case <-c.Notifier.Quit:
@@ -201,16 +251,101 @@ func (c *Config) watchEvents(watcher *fsnotify.Watcher) {
 	}
 }
 
+// reload loads the archive after it changed. A downloaded archive which
+// fails to load is replaced by the previous one, if there is any, so the
+// database keeps working after a restart as well.
+func (c *Config) reload() {
+	c.swap.Lock()
+	defer c.swap.Unlock()
+	pending := c.pending
+	c.pending = nil
+	err := c.openFile()
+	if err == nil {
+		if pending != nil {
+			if err := c.writeMeta(pending); err != nil {
+				c.SendError(err)
+			}
+			c.setDates()
+		}
+		return
+	}
+	c.SendError(fmt.Errorf("%s failed to load: %s", c.Archive, err))
+	if c.Mode == ModeFile || !c.HasPrevious() {
+		return
+	}
+	if err := os.Rename(c.Archive+".bak", c.Archive); err != nil {
+		c.SendError(err)
+		return
+	}
+	c.SendInfo("restored previous version of " + c.Archive)
+}
+
+// HasPrevious reports whether a previous version of a downloaded database
+// is available for a rollback.
+func (c *Config) HasPrevious() bool {
+	_, err := os.Stat(c.Archive + ".bak")
+	return err == nil
+}
+
+// Rollback restores the previous version of a downloaded database and
+// loads it. The current version becomes the previous one, so a second
+// rollback undoes the first.
+func (c *Config) Rollback() error {
+	if c.Mode == ModeFile || c.Mode == ModeDisabled {
+		return fmt.Errorf("rollback requires a downloaded database")
+	}
+	c.swap.Lock()
+	defer c.swap.Unlock()
+	if !c.HasPrevious() {
+		return fmt.Errorf("no previous version of %s", c.Archive)
+	}
+	// A download which has not been loaded yet is superseded
+	c.pending = nil
+
+	tmpFile := fmt.Sprintf(c.Archive + "%d", time.Now().UnixNano())
+	if err := os.Rename(c.Archive, tmpFile); err != nil {
+		return err
+	}
+	if err := os.Rename(c.Archive+".bak", c.Archive); err != nil {
+		if err := os.Rename(tmpFile, c.Archive); err != nil {}
+		return err
+	}
+	if err := os.Rename(tmpFile, c.Archive+".bak"); err != nil {
+		return err
+	}
+	// The validators of the last download are kept, so the update check
+	// does not install the same version again right away.
+	if err := c.openFile(); err != nil {
+		return err
+	}
+	c.SendInfo("rolled back to previous version of " + c.Archive)
+	return nil
+}
+
 func (c *Config) openFile() error {
 	err := c.cbk()
 	if err != nil {
 		return err
 	}
+	return c.setDates()
+}
+
+// setDates updates the dates of the database from the file and the
+// validators of the last download.
+func (c *Config) setDates() error {
 	stat, err := os.Stat(c.File)
 	if err != nil {
 		return err
 	}
-	c.LastUpdated = stat.ModTime()
+
+	m := c.readMeta()
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.LastUpdated = stat.ModTime().UTC()
+	if modified := m.modified(); !modified.IsZero() && c.Mode != ModeFile {
+		c.LastUpdated = modified.UTC()
+	}
+	c.Downloaded = m.Downloaded
 
 	return nil
 }
@@ -228,10 +363,23 @@ func (c *Config)  Close() {
 	}
 }
 
-// Date returns the UTC date the database file was last modified.
+// Date returns the UTC date of the database. This is the build date
+// stored in the database if there is one, the Last-Modified date of the
+// download or the modification time of the local file otherwise.
 // If no database file has been opened the behaviour of Date is undefined.
 func (c *Config) Date() time.Time {
 	c.Mu.RLock()
 	defer c.Mu.RUnlock()
+	if !c.Built.IsZero() {
+		return c.Built
+	}
 	return c.LastUpdated
-}
\ No newline at end of file
+}
+
+// DownloadDate returns the UTC time the database was last downloaded or
+// the zero time if it has never been downloaded.
+func (c *Config) DownloadDate() time.Time {
+	c.Mu.RLock()
+	defer c.Mu.RUnlock()
+	return c.Downloaded
+}
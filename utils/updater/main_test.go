@@ -0,0 +1,223 @@
+package updater
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testLastModified = "Tue, 04 Feb 2020 10:00:00 GMT"
+
+// testServer serves body with the given validators and answers matching
+// conditional requests with 304 Not Modified.
+type testServer struct {
+	mutex    sync.Mutex
+	etag     string
+	body     string
+	status   int
+	requests []*http.Request
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.requests = append(ts.requests, r)
+	if ts.status != 0 {
+		w.WriteHeader(ts.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == ts.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", ts.etag)
+	w.Header().Set("Last-Modified", testLastModified)
+	_, _ = w.Write([]byte(ts.body))
+}
+
+func (ts *testServer) last() *http.Request {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return ts.requests[len(ts.requests)-1]
+}
+
+// newTestConfig creates a config downloading from url into a temporary
+// directory. The archive is loaded as is and rejected if load returns an
+// error.
+func newTestConfig(t *testing.T, url string, load func(content string) error) *Config {
+	dir := t.TempDir()
+	c := NewDefaultConfig(time.Hour, time.Hour, filepath.Join(dir, "test.db"), filepath.Join(dir, "test.db.gz"), url, nil)
+	c.cbk = func() error {
+		content, err := ioutil.ReadFile(c.Archive)
+		if err != nil {
+			return err
+		}
+		if err := load(string(content)); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(c.File, content, 0644)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func accept(string) error { return nil }
+
+func readArchive(t *testing.T, c *Config) string {
+	content, err := ioutil.ReadFile(c.Archive)
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	return string(content)
+}
+
+func TestUpdateConditional(t *testing.T) {
+	ts := &testServer{etag: `"v1"`, body: "v1"}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+	c := newTestConfig(t, srv.URL, accept)
+
+	if err := c.Update(); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	if h := ts.last().Header.Get("If-None-Match"); h != "" {
+		t.Errorf("first update sent If-None-Match %s", h)
+	}
+	if got := readArchive(t, c); got != "v1" {
+		t.Fatalf("archive: got %q, want v1", got)
+	}
+	c.reload()
+
+	lastModified, _ := http.ParseTime(testLastModified)
+	if !c.Date().Equal(lastModified) {
+		t.Errorf("date: got %v, want %v", c.Date(), lastModified)
+	}
+	if c.DownloadDate().IsZero() {
+		t.Error("download date has not been set")
+	}
+
+	// The server answers with 304 as long as the etag matches
+	if err := c.Update(); err != nil {
+		t.Fatalf("second update: %v", err)
+	}
+	r := ts.last()
+	if got := r.Header.Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match: got %q", got)
+	}
+	if got := r.Header.Get("If-Modified-Since"); got != testLastModified {
+		t.Errorf("If-Modified-Since: got %q", got)
+	}
+	if got := readArchive(t, c); got != "v1" {
+		t.Errorf("archive changed by 304 response: got %q", got)
+	}
+	if c.HasPrevious() {
+		t.Error("304 response created a previous version")
+	}
+
+	// A new version is downloaded and the previous one kept
+	ts.mutex.Lock()
+	ts.etag, ts.body = `"v2"`, "v2"
+	ts.mutex.Unlock()
+	if err := c.Update(); err != nil {
+		t.Fatalf("third update: %v", err)
+	}
+	c.reload()
+	if got := readArchive(t, c); got != "v2" {
+		t.Errorf("archive: got %q, want v2", got)
+	}
+	if m := c.readMeta(); m.ETag != `"v2"` {
+		t.Errorf("stored etag: got %q, want v2", m.ETag)
+	}
+	if !c.HasPrevious() {
+		t.Error("previous version has not been kept")
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusUnauthorized, http.StatusInternalServerError} {
+		ts := &testServer{etag: `"v1"`, status: status}
+		srv := httptest.NewServer(ts)
+		c := newTestConfig(t, srv.URL, accept)
+
+		err := c.Update()
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), http.StatusText(status)) {
+			t.Errorf("status %d: got error %v", status, err)
+		}
+		files, _ := ioutil.ReadDir(filepath.Dir(c.Archive))
+		if len(files) != 0 {
+			t.Errorf("status %d: files left behind: %d", status, len(files))
+		}
+	}
+}
+
+func TestReloadRejectedDownload(t *testing.T) {
+	ts := &testServer{etag: `"v1"`, body: "v1"}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+	c := newTestConfig(t, srv.URL, func(content string) error {
+		if content == "broken" {
+			return errors.New("invalid database")
+		}
+		return nil
+	})
+
+	if err := c.Update(); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	c.reload()
+
+	ts.mutex.Lock()
+	ts.etag, ts.body = `"v2"`, "broken"
+	ts.mutex.Unlock()
+	if err := c.Update(); err != nil {
+		t.Fatalf("second update: %v", err)
+	}
+	c.reload()
+
+	if got := readArchive(t, c); got != "v1" {
+		t.Errorf("previous archive has not been restored: got %q", got)
+	}
+	// The validators still belong to the restored archive, so the broken
+	// version is not mistaken for being up to date
+	if m := c.readMeta(); m.ETag != `"v1"` {
+		t.Errorf("stored etag: got %q, want v1", m.ETag)
+	}
+	if err := c.Update(); err != nil {
+		t.Fatalf("third update: %v", err)
+	}
+	if got := ts.last().Header.Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match after restore: got %q, want v1", got)
+	}
+	if got := readArchive(t, c); got != "broken" {
+		t.Errorf("rejected version has not been downloaded again: got %q", got)
+	}
+}
+
+func TestUpdateWithoutArchive(t *testing.T) {
+	ts := &testServer{etag: `"v1"`, body: "v1"}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+	c := newTestConfig(t, srv.URL, accept)
+
+	// Stale validators must not keep a missing archive from being downloaded
+	if err := c.writeMeta(&meta{ETag: `"v1"`, LastModified: testLastModified}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if h := ts.last().Header.Get("If-None-Match"); h != "" {
+		t.Errorf("If-None-Match sent without archive: %s", h)
+	}
+	if _, err := os.Stat(c.Archive); err != nil {
+		t.Errorf("archive has not been downloaded: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// meta holds the validators of the last download. It is stored next to
+// the archive and sent along with the next update check, so that the
+// archive is only downloaded again if it changed.
+type meta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Downloaded   time.Time `json:"downloaded"`
+}
+
+func (c *Config) metaFile() string {
+	return c.Archive + ".meta"
+}
+
+// readMeta returns the stored validators or empty ones if there are none.
+func (c *Config) readMeta() *meta {
+	m := &meta{}
+	content, err := ioutil.ReadFile(c.metaFile())
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		return &meta{}
+	}
+	return m
+}
+
+func (c *Config) writeMeta(m *meta) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.metaFile(), content, 0644)
+}
+
+// modified returns the Last-Modified date of the download or the zero
+// time if the server did not send one.
+func (m *meta) modified() time.Time {
+	t, err := http.ParseTime(m.LastModified)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// conditional adds the validators to the request. Nothing is added if the
+// archive is missing, so that it is downloaded in any case.
+func (c *Config) conditional(req *http.Request) {
+	if _, err := os.Stat(c.Archive); err != nil {
+		return
+	}
+	m := c.readMeta()
+	if m.ETag != "" {
+		req.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		req.Header.Set("If-Modified-Since", m.LastModified)
+	}
+}
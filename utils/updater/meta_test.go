@@ -0,0 +1,43 @@
+package updater
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetaRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{Archive: filepath.Join(dir, "test.db.gz")}
+
+	if m := c.readMeta(); *m != (meta{}) {
+		t.Errorf("missing sidecar: got %+v", m)
+	}
+
+	want := meta{
+		ETag:         `"abc"`,
+		LastModified: testLastModified,
+		Downloaded:   time.Date(2020, 2, 4, 11, 0, 0, 0, time.UTC),
+	}
+	if err := c.writeMeta(&want); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := ioutil.ReadFile(c.Archive + ".meta"); err != nil {
+		t.Fatalf("sidecar not stored next to the archive: %v", err)
+	}
+	got := c.readMeta()
+	if got.ETag != want.ETag || got.LastModified != want.LastModified || !got.Downloaded.Equal(want.Downloaded) {
+		t.Errorf("read: got %+v, want %+v", got, want)
+	}
+	if modified := got.modified(); !modified.Equal(time.Date(2020, 2, 4, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("modified: got %v", modified)
+	}
+
+	if err := ioutil.WriteFile(c.metaFile(), []byte("{broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m := c.readMeta(); *m != (meta{}) {
+		t.Errorf("corrupt sidecar: got %+v", m)
+	}
+}